@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const sessionCookie = "session"
+
+func hashPassword(password string) string {
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	panicErr(err)
+	return string(h)
+}
+
+func checkPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// compared against when account doesn't exist, so that timing doesn't reveal that
+var dummyHash struct {
+	once sync.Once
+	hash string
+}
+
+func checkLogin(db *sql.DB, username, password string) bool {
+	var hash string
+	err := sqlStmt(db, "SELECT password FROM admins WHERE username=$1").QueryRow(username).Scan(&hash)
+	if err == sql.ErrNoRows {
+		dummyHash.once.Do(func() { dummyHash.hash = hashPassword("dummy") })
+		checkPassword(dummyHash.hash, password)
+		return false
+	}
+	panicErr(err)
+	return checkPassword(hash, password)
+}
+
+// only hash of session token is stored in database
+func sessionHash(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+func newSession(db *sql.DB, username string) (token string, expires int64) {
+	var b [32]byte
+	_, err := rand.Read(b[:])
+	panicErr(err)
+	token = hex.EncodeToString(b[:])
+
+	now := utcUnixTime()
+	expires = now + int64(cfg.Admin.SessionLifetime)
+
+	// good moment to get rid of stale sessions
+	_, err = sqlStmt(db, "DELETE FROM admin_sessions WHERE expires <= $1").Exec(now)
+	panicErr(err)
+
+	_, err = sqlStmt(db, "INSERT INTO admin_sessions (id, username, expires) VALUES ($1, $2, $3)").Exec(sessionHash(token), username, expires)
+	panicErr(err)
+	return
+}
+
+// account roles
+const (
+	roleAdmin = "admin" // can do everything, including creating and deleting boards
+	roleMod   = "mod"   // can moderate only boards assigned to them
+)
+
+type adminAccount struct {
+	Name string
+	Role string
+}
+
+func (a *adminAccount) IsAdmin() bool {
+	return a.Role == roleAdmin
+}
+
+// whether account may moderate board
+func (a *adminAccount) mayModerate(db *sql.DB, board string) bool {
+	if a.IsAdmin() {
+		return true
+	}
+	var n int
+	err := sqlStmt(db, "SELECT COUNT(*) FROM admin_boards WHERE username=$1 AND board=$2").QueryRow(a.Name, board).Scan(&n)
+	panicErr(err)
+	return n != 0
+}
+
+// returns logged in account, or nil if there is no valid session
+func sessionAccount(db *sql.DB, r *http.Request) *adminAccount {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || c.Value == "" {
+		return nil
+	}
+	var a adminAccount
+	q := `SELECT a.username, a.role FROM admin_sessions AS s
+	JOIN admins AS a ON a.username = s.username
+	WHERE s.id=$1 AND s.expires > $2`
+	err = sqlStmt(db, q).QueryRow(sessionHash(c.Value), utcUnixTime()).Scan(&a.Name, &a.Role)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	panicErr(err)
+	return &a
+}
+
+// checks whether request comes from logged in account, and if not, reports it
+func requireLogin(w http.ResponseWriter, r *http.Request) (*adminAccount, bool) {
+	a := sessionAccount(sqlPool(), r)
+	if a != nil {
+		return a, true
+	}
+	if wantJSON(r) || r.Method != "GET" {
+		reportError(w, r, newReqError(401, errNotLoggedIn, "login required"))
+	} else {
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+	}
+	return nil, false
+}
+
+// like requireLogin, but also requires global admin role
+func requireAdmin(w http.ResponseWriter, r *http.Request) (*adminAccount, bool) {
+	a, ok := requireLogin(w, r)
+	if !ok {
+		return nil, false
+	}
+	if !a.IsAdmin() {
+		reportError(w, r, newReqError(403, errForbidden, "admin role required"))
+		return nil, false
+	}
+	return a, true
+}
+
+// like requireLogin, but also requires permission to moderate board
+func requireBoardMod(w http.ResponseWriter, r *http.Request, board string) (*adminAccount, bool) {
+	a, ok := requireLogin(w, r)
+	if !ok {
+		return nil, false
+	}
+	if !a.mayModerate(sqlPool(), board) {
+		reportError(w, r, newReqError(403, errForbidden, "not allowed to moderate this board"))
+		return nil, false
+	}
+	return a, true
+}
+
+type loginInfo struct {
+	Next  string
+	Error string
+}
+
+// only allow local redirects after login
+func loginNext(next string) string {
+	if next == "" || next[0] != '/' || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func renderLogin(w http.ResponseWriter, r *http.Request) {
+	li := loginInfo{Next: loginNext(r.FormValue("next"))}
+	execTemplate(w, "login", &li)
+}
+
+func postLogin(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	username, password := r.PostFormValue("username"), r.PostFormValue("password")
+	next := loginNext(r.PostFormValue("next"))
+
+	db := sqlPool()
+
+	if username == "" || !checkLogin(db, username, password) {
+		w.WriteHeader(403)
+		li := loginInfo{Next: next, Error: "wrong username or password"}
+		execTemplate(w, "login", &li)
+		return
+	}
+
+	token, expires := newSession(db, username)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Unix(expires, 0),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+func postLogout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookie); err == nil && c.Value != "" {
+		_, err = sqlStmt(sqlPool(), "DELETE FROM admin_sessions WHERE id=$1").Exec(sessionHash(c.Value))
+		panicErr(err)
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// adds account or changes password and role of existing one.
+// password is read from stdin so that it doesn't show up in process list
+func addAdminCmd(username, role string) {
+	if username == "" {
+		fmt.Printf("usage: addadmin <username> [admin|mod]\n")
+		return
+	}
+	if role == "" {
+		role = roleAdmin
+	}
+	if role != roleAdmin && role != roleMod {
+		fmt.Printf("error: unknown role %s\n", role)
+		return
+	}
+	fmt.Printf("password for %s: ", username)
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		fmt.Printf("\nerror: %s\n", err)
+		return
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		fmt.Printf("error: empty password\n")
+		return
+	}
+
+	q := `INSERT INTO admins (username, password, role) VALUES ($1, $2, $3)
+	ON CONFLICT (username) DO UPDATE SET password = EXCLUDED.password, role = EXCLUDED.role`
+	_, err = sqlStmt(sqlPool(), q).Exec(username, hashPassword(password), role)
+	panicErr(err)
+
+	fmt.Printf("%s %s saved.\n", role, username)
+}
+
+// assigns board to moderator, or takes it away
+func assignBoardCmd(username, board string, assign bool) {
+	if username == "" || board == "" {
+		fmt.Printf("usage: assign|unassign <username> <board>\n")
+		return
+	}
+	db := sqlPool()
+	if !sqlValidateBoard(db, board) {
+		fmt.Printf("error: board does not exist\n")
+		return
+	}
+	var err error
+	if assign {
+		_, err = sqlStmt(db, "INSERT INTO admin_boards (username, board) VALUES ($1, $2) ON CONFLICT DO NOTHING").Exec(username, board)
+	} else {
+		_, err = sqlStmt(db, "DELETE FROM admin_boards WHERE username=$1 AND board=$2").Exec(username, board)
+	}
+	panicErr(err)
+	fmt.Printf("done.\n")
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestLoginNext(t *testing.T) {
+	type nextset struct {
+		src  string
+		next string
+	}
+	var tests = [...]nextset{
+		{src: "", next: "/"},
+		{src: "/", next: "/"},
+		{src: "/b/mod/", next: "/b/mod/"},
+		{src: "/mod/reports?x=1", next: "/mod/reports?x=1"},
+		{src: "b/mod/", next: "/"},
+		{src: "//evil.example/", next: "/"},
+		{src: "/\\evil.example/", next: "/"},
+		{src: "https://evil.example/", next: "/"},
+		{src: "javascript:alert(1)", next: "/"},
+	}
+	for i := range tests {
+		if next := loginNext(tests[i].src); next != tests[i].next {
+			t.Errorf("loginNext(%q): expected: %s; got: %s\n", tests[i].src, tests[i].next, next)
+		}
+	}
+}
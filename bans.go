@@ -0,0 +1,352 @@
+package main
+
+import (
+	"database/sql"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// address of poster. if we're behind reverse proxy, it's taken from headers proxy sets
+func clientIP(r *http.Request) net.IP {
+	if cfg.Server.TrustProxy {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			// rightmost entry is added by our proxy, others can be spoofed
+			parts := strings.Split(xff, ",")
+			if ip := net.ParseIP(strings.TrimSpace(parts[len(parts)-1])); ip != nil {
+				return ip
+			}
+		}
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// value for inet columns
+func sqlIP(ip net.IP) interface{} {
+	if ip == nil {
+		return nil
+	}
+	return ip.String()
+}
+
+type banInfo struct {
+	IP      string // address or range in CIDR notation
+	Reason  string
+	Date    int64
+	Expires int64 // 0 means never
+}
+
+func (b *banInfo) HasExpiry() bool {
+	return b.Expires != 0
+}
+
+func (b *banInfo) StrExpires() string {
+	if !b.HasExpiry() {
+		return "never"
+	}
+	return time.Unix(b.Expires, 0).UTC().Format("2006-01-02 15:04:05 UTC")
+}
+
+func (b *banInfo) StrDate() string {
+	if b.Date == 0 {
+		return "unknown"
+	}
+	return time.Unix(b.Date, 0).UTC().Format("2006-01-02 15:04:05 UTC")
+}
+
+// finds active ban covering ip. longest lasting one is returned if there are multiple
+func sqlFindBan(db *sql.DB, ip net.IP, b *banInfo) bool {
+	if ip == nil {
+		return false
+	}
+	q := `SELECT ip_addr, reason, date, expires FROM ip_bans
+	WHERE ip_addr >>= $1 AND (expires IS NULL OR expires > $2)
+	ORDER BY expires DESC NULLS FIRST
+	LIMIT 1`
+	var date, expires sql.NullInt64
+	err := sqlStmt(db, q).QueryRow(sqlIP(ip), utcUnixTime()).Scan(&b.IP, &b.Reason, &date, &expires)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	panicErr(err)
+	b.Date, b.Expires = date.Int64, expires.Int64
+	return true
+}
+
+// refuses request if poster is banned. returns true if request may proceed
+func checkBan(w http.ResponseWriter, r *http.Request, db *sql.DB) bool {
+	var b banInfo
+	if !sqlFindBan(db, clientIP(r), &b) {
+		return true
+	}
+	if wantJSON(r) {
+		reportError(w, r, newReqError(403, errBanned, "you are banned: "+b.Reason+" (expires: "+b.StrExpires()+")"))
+	} else {
+		w.WriteHeader(403)
+		execTemplate(w, "banned", &b)
+	}
+	return false
+}
+
+func (b *banInfo) IsActive() bool {
+	return !b.HasExpiry() || b.Expires > utcUnixTime()
+}
+
+// normalizes address or CIDR range entered by moderator
+func parseBanRange(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if strings.IndexByte(s, '/') != -1 {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return "", false
+		}
+		ones, bits := n.Mask.Size()
+		if !banPrefixOK(ones, bits) {
+			return "", false
+		}
+		return n.String(), true
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return "", false
+	}
+	return ip.String(), true
+}
+
+// shortest prefixes bans may use, so that single ban can't lock out everyone
+const (
+	minBanPrefix4 = 8
+	minBanPrefix6 = 32
+)
+
+func banPrefixOK(ones, bits int) bool {
+	if bits == 32 {
+		return ones >= minBanPrefix4
+	}
+	return ones >= minBanPrefix6 && ones <= bits
+}
+
+// range of given prefix length around ip, or just ip if prefix is empty
+func banRange(ip net.IP, prefix string) (string, bool) {
+	if prefix == "" {
+		return ip.String(), true
+	}
+	bits := 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 32
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(prefix, "/"))
+	if err != nil || n > bits || !banPrefixOK(n, bits) {
+		return "", false
+	}
+	ipn := net.IPNet{IP: ip.Mask(net.CIDRMask(n, bits)), Mask: net.CIDRMask(n, bits)}
+	return ipn.String(), true
+}
+
+// ban duration in seconds from form value, 0 means permanent
+func parseBanDuration(s string) (int64, bool) {
+	d, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || d < 0 {
+		return 0, false
+	}
+	return d, true
+}
+
+func saveBan(db *sql.DB, b *banInfo) {
+	var expires sql.NullInt64
+	if b.HasExpiry() {
+		expires = sql.NullInt64{Int64: b.Expires, Valid: true}
+	}
+	q := `INSERT INTO ip_bans (ip_addr, reason, date, expires) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (ip_addr) DO UPDATE SET reason = EXCLUDED.reason, date = EXCLUDED.date, expires = EXCLUDED.expires`
+	_, err := sqlStmt(db, q).Exec(b.IP, b.Reason, b.Date, expires)
+	panicErr(err)
+}
+
+type banResult struct {
+	postResult
+	IP      string `json:"ip"`
+	Deleted bool   `json:"deleted"`
+}
+
+// bans author of post, from moderator view
+func postBan(w http.ResponseWriter, r *http.Request, board string) {
+	r.ParseForm()
+	spost, ok := r.PostForm["id"]
+	if !ok {
+		reportError(w, r, newReqError(400, errMissingField, "no post id specified"))
+		return
+	}
+	post, err := strconv.ParseUint(spost[0], 10, 64)
+	if err != nil {
+		reportError(w, r, newReqError(400, errBadPostId, "bad post id"))
+		return
+	}
+	duration, ok := parseBanDuration(r.PostFormValue("duration"))
+	if !ok {
+		reportError(w, r, newReqError(400, errBadRequest, "bad ban duration"))
+		return
+	}
+	reason := r.PostFormValue("reason")
+	if reason == "" {
+		reason = "No reason specified"
+	}
+
+	db := sqlPool()
+
+	if !sqlValidateBoard(db, board) {
+		reportError(w, r, newReqError(404, errBoardNotFound, "board not found"))
+		return
+	}
+
+	var thread sql.NullInt64
+	var ipaddr sql.NullString
+	err = boardStmt(db, board, "SELECT thread, ip_addr FROM %s.posts WHERE id=$1").QueryRow(post).Scan(&thread, &ipaddr)
+	if err == sql.ErrNoRows {
+		reportError(w, r, newReqError(404, errPostNotFound, "post not found"))
+		return
+	}
+	panicErr(err)
+	if !ipaddr.Valid {
+		reportError(w, r, newReqError(400, errNoAddress, "post has no recorded address"))
+		return
+	}
+	addr := ipaddr.String
+	if i := strings.IndexByte(addr, '/'); i != -1 {
+		addr = addr[:i]
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		reportError(w, r, newReqError(500, errInternal, "bad recorded address"))
+		return
+	}
+
+	var br banResult
+	br.Board, br.Post = board, post
+	if thread.Valid && thread.Int64 != 0 {
+		br.Thread = uint64(thread.Int64)
+	} else {
+		br.Thread = post
+	}
+
+	br.IP, ok = banRange(ip, r.PostFormValue("range"))
+	if !ok {
+		reportError(w, r, newReqError(400, errBadRequest, "bad ban range"))
+		return
+	}
+
+	b := banInfo{IP: br.IP, Reason: reason, Date: utcUnixTime()}
+	if duration != 0 {
+		b.Expires = b.Date + duration
+	}
+	saveBan(db, &b)
+	logAction(db, &logEntry{Actor: logActor(r), Action: logBan, Board: board, Thread: br.Thread, Post: post, Target: b.IP, Reason: reason})
+
+	if r.PostFormValue("delete") != "" {
+		if !removePost(w, r, &br.postResult, board, post, logActor(r), reason) {
+			return
+		}
+		br.Deleted = true
+	} else if r.PostFormValue("note") != "" {
+		_, err = boardStmt(db, board, "UPDATE %s.posts SET banned = true WHERE id=$1").Exec(post)
+		panicErr(err)
+	}
+
+	reportResult(w, r, "postbanned", &br)
+}
+
+type bansInfo struct {
+	Bans []banInfo
+}
+
+func renderBans(w http.ResponseWriter, r *http.Request) {
+	db := sqlPool()
+
+	var bi bansInfo
+	rows, err := sqlStmt(db, "SELECT ip_addr, reason, date, expires FROM ip_bans ORDER BY date DESC NULLS LAST").Query()
+	panicErr(err)
+	defer rows.Close()
+	for rows.Next() {
+		var b banInfo
+		var date, expires sql.NullInt64
+		err = rows.Scan(&b.IP, &b.Reason, &date, &expires)
+		panicErr(err)
+		b.Date, b.Expires = date.Int64, expires.Int64
+		bi.Bans = append(bi.Bans, b)
+	}
+
+	if wantJSON(r) {
+		execJSON(w, bi.Bans)
+	} else {
+		execTemplate(w, "bans", &bi)
+	}
+}
+
+// adds new ban or edits existing one
+func postBansEdit(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	ip, ok := parseBanRange(r.PostFormValue("ip"))
+	if !ok {
+		reportError(w, r, newReqError(400, errBadRequest, "bad address or range"))
+		return
+	}
+	reason := r.PostFormValue("reason")
+	if reason == "" {
+		reason = "No reason specified"
+	}
+
+	db := sqlPool()
+
+	sduration := r.PostFormValue("duration")
+	if sduration == "keep" {
+		res, err := sqlStmt(db, "UPDATE ip_bans SET reason=$2 WHERE ip_addr=$1").Exec(ip, reason)
+		panicErr(err)
+		if n, _ := res.RowsAffected(); n == 0 {
+			reportError(w, r, newReqError(404, errBanNotFound, "ban not found"))
+			return
+		}
+		logAction(db, &logEntry{Actor: logActor(r), Action: logEditBan, Target: ip, Reason: reason})
+	} else {
+		duration, ok := parseBanDuration(sduration)
+		if !ok {
+			reportError(w, r, newReqError(400, errBadRequest, "bad ban duration"))
+			return
+		}
+		b := banInfo{IP: ip, Reason: reason, Date: utcUnixTime()}
+		if duration != 0 {
+			b.Expires = b.Date + duration
+		}
+		saveBan(db, &b)
+		logAction(db, &logEntry{Actor: logActor(r), Action: logBan, Target: ip, Reason: reason})
+	}
+
+	reportDone(w, r, "/mod/bans")
+}
+
+func postBansLift(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	ip, ok := parseBanRange(r.PostFormValue("ip"))
+	if !ok {
+		reportError(w, r, newReqError(400, errBadRequest, "bad address or range"))
+		return
+	}
+
+	db := sqlPool()
+
+	res, err := sqlStmt(db, "DELETE FROM ip_bans WHERE ip_addr=$1").Exec(ip)
+	panicErr(err)
+	if n, _ := res.RowsAffected(); n != 0 {
+		logAction(db, &logEntry{Actor: logActor(r), Action: logLiftBan, Target: ip, Reason: r.PostFormValue("reason")})
+	}
+
+	reportDone(w, r, "/mod/bans")
+}
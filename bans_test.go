@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseBanRange(t *testing.T) {
+	type rangeset struct {
+		src string
+		res string
+		ok  bool
+	}
+	var tests = [...]rangeset{
+		{src: "1.2.3.4", res: "1.2.3.4", ok: true},
+		{src: " 1.2.3.4 ", res: "1.2.3.4", ok: true},
+		{src: "1.2.3.4/24", res: "1.2.3.0/24", ok: true},
+		{src: "1.2.3.4/8", res: "1.0.0.0/8", ok: true},
+		{src: "1.2.3.4/7", res: "", ok: false},
+		{src: "0.0.0.0/0", res: "", ok: false},
+		{src: "2001:db8::1", res: "2001:db8::1", ok: true},
+		{src: "2001:db8::1/64", res: "2001:db8::/64", ok: true},
+		{src: "2001:db8::1/32", res: "2001:db8::/32", ok: true},
+		{src: "2001:db8::1/31", res: "", ok: false},
+		{src: "::/0", res: "", ok: false},
+		{src: "1.2.3.4/33", res: "", ok: false},
+		{src: "", res: "", ok: false},
+		{src: "example.com", res: "", ok: false},
+	}
+	for i := range tests {
+		res, ok := parseBanRange(tests[i].src)
+		if res != tests[i].res || ok != tests[i].ok {
+			t.Errorf("parseBanRange(%q): expected: %q, %v; got: %q, %v\n", tests[i].src, tests[i].res, tests[i].ok, res, ok)
+		}
+	}
+}
+
+func TestBanRange(t *testing.T) {
+	type rangeset struct {
+		ip     string
+		prefix string
+		res    string
+		ok     bool
+	}
+	var tests = [...]rangeset{
+		{ip: "1.2.3.4", prefix: "", res: "1.2.3.4", ok: true},
+		{ip: "1.2.3.4", prefix: "24", res: "1.2.3.0/24", ok: true},
+		{ip: "1.2.3.4", prefix: "/16", res: "1.2.0.0/16", ok: true},
+		{ip: "1.2.3.4", prefix: "32", res: "1.2.3.4/32", ok: true},
+		{ip: "1.2.3.4", prefix: "33", res: "", ok: false},
+		{ip: "1.2.3.4", prefix: "4", res: "", ok: false},
+		{ip: "1.2.3.4", prefix: "0", res: "", ok: false},
+		{ip: "1.2.3.4", prefix: "-8", res: "", ok: false},
+		{ip: "1.2.3.4", prefix: "x", res: "", ok: false},
+		{ip: "2001:db8:1:2::1", prefix: "64", res: "2001:db8:1:2::/64", ok: true},
+		{ip: "2001:db8:1:2::1", prefix: "128", res: "2001:db8:1:2::1/128", ok: true},
+		{ip: "2001:db8:1:2::1", prefix: "129", res: "", ok: false},
+		{ip: "2001:db8:1:2::1", prefix: "16", res: "", ok: false},
+	}
+	for i := range tests {
+		res, ok := banRange(net.ParseIP(tests[i].ip), tests[i].prefix)
+		if res != tests[i].res || ok != tests[i].ok {
+			t.Errorf("banRange(%s, %q): expected: %q, %v; got: %q, %v\n", tests[i].ip, tests[i].prefix, tests[i].res, tests[i].ok, res, ok)
+		}
+	}
+}
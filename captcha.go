@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"math/big"
+	mrand "math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// captcha challenges aren't stored. token carries answer and expiry, encrypted with
+// key known only to this process, so any number of them can be handed out. only
+// tokens which were used are remembered, until they expire, so each works once.
+// restart invalidates outstanding tokens, which only costs posters a retry
+var captchaAEAD = func() cipher.AEAD {
+	var key [32]byte
+	_, err := rand.Read(key[:])
+	panicErr(err)
+	block, err := aes.NewCipher(key[:])
+	panicErr(err)
+	aead, err := cipher.NewGCM(block)
+	panicErr(err)
+	return aead
+}()
+
+// seeds noise of challenge images. derived from token with secret key, so that
+// same token always gives same image, but image can't be predicted for some answer
+var captchaSeedKey = func() []byte {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	panicErr(err)
+	return key
+}()
+
+var usedCaptchas = struct {
+	sync.Mutex
+	m         map[string]int64 // token -> expiry
+	lastSweep int64
+}{m: make(map[string]int64)}
+
+// 5x7 digit glyphs, one string per row
+var captchaGlyphs = [10][7]string{
+	{" ### ", "#   #", "#  ##", "# # #", "##  #", "#   #", " ### "},
+	{"  #  ", " ##  ", "  #  ", "  #  ", "  #  ", "  #  ", " ### "},
+	{" ### ", "#   #", "    #", "   # ", "  #  ", " #   ", "#####"},
+	{"#####", "   # ", "  #  ", "   # ", "    #", "#   #", " ### "},
+	{"   # ", "  ## ", " # # ", "#  # ", "#####", "   # ", "   # "},
+	{"#####", "#    ", "#### ", "    #", "    #", "#   #", " ### "},
+	{"  ## ", " #   ", "#    ", "#### ", "#   #", "#   #", " ### "},
+	{"#####", "    #", "   # ", "  #  ", " #   ", " #   ", " #   "},
+	{" ### ", "#   #", "#   #", " ### ", "#   #", "#   #", " ### "},
+	{" ### ", "#   #", "#   #", " ####", "    #", "   # ", " ##  "},
+}
+
+const (
+	captchaScale  = 4
+	captchaWidth  = 200
+	captchaHeight = 56
+)
+
+func randInt(n int) int {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	panicErr(err)
+	return int(v.Int64())
+}
+
+// creates new challenge and returns its token
+func newCaptcha() string {
+	answer := make([]byte, cfg.Captcha.Length)
+	for i := range answer {
+		answer[i] = byte('0' + randInt(10))
+	}
+	expires := time.Now().Unix() + int64(cfg.Captcha.Lifetime)
+	plain := strconv.FormatInt(expires, 10) + ":" + string(answer)
+
+	nonce := make([]byte, captchaAEAD.NonceSize())
+	_, err := rand.Read(nonce)
+	panicErr(err)
+	return base64.RawURLEncoding.EncodeToString(captchaAEAD.Seal(nonce, nonce, []byte(plain), nil))
+}
+
+// answer and expiry carried by token, if token was made by us and hasn't expired
+func openCaptcha(token string) (answer string, expires int64, ok bool) {
+	// strict, so that token can't be respelled to get around used token check
+	b, err := base64.RawURLEncoding.Strict().DecodeString(token)
+	if err != nil || len(b) < captchaAEAD.NonceSize() {
+		return "", 0, false
+	}
+	ns := captchaAEAD.NonceSize()
+	plain, err := captchaAEAD.Open(nil, b[:ns], b[ns:], nil)
+	if err != nil {
+		return "", 0, false
+	}
+	i := bytes.IndexByte(plain, ':')
+	if i == -1 {
+		return "", 0, false
+	}
+	expires, err = strconv.ParseInt(string(plain[:i]), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "", 0, false
+	}
+	return string(plain[i+1:]), expires, true
+}
+
+func captchaAnswer(token string) (string, bool) {
+	answer, _, ok := openCaptcha(token)
+	if !ok {
+		return "", false
+	}
+	usedCaptchas.Lock()
+	_, used := usedCaptchas.m[token]
+	usedCaptchas.Unlock()
+	return answer, !used
+}
+
+// checks answer. challenge is used up whether answer was right or not
+func solveCaptcha(token, answer string) bool {
+	want, expires, ok := openCaptcha(token)
+	if !ok {
+		return false
+	}
+
+	now := time.Now().Unix()
+	usedCaptchas.Lock()
+	defer usedCaptchas.Unlock()
+	if _, used := usedCaptchas.m[token]; used {
+		return false
+	}
+	usedCaptchas.m[token] = expires
+	// expired tokens are rejected anyway, so there's no need to remember them
+	if now-usedCaptchas.lastSweep >= 60 {
+		for k, e := range usedCaptchas.m {
+			if now > e {
+				delete(usedCaptchas.m, k)
+			}
+		}
+		usedCaptchas.lastSweep = now
+	}
+	return strings.TrimSpace(answer) == want
+}
+
+func boardCaptcha(db *sql.DB, board string) bool {
+	var captcha bool
+	err := sqlStmt(db, "SELECT captcha FROM boards WHERE name=$1").QueryRow(board).Scan(&captcha)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	panicErr(err)
+	return captcha
+}
+
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.Color) {
+	if x0 == x1 && y0 == y1 {
+		img.Set(x0, y0, c)
+		return
+	}
+	dx, dy := x1-x0, y1-y0
+	if dx < 0 {
+		dx = -dx
+	}
+	if dy < 0 {
+		dy = -dy
+	}
+	steps := dx
+	if dy > steps {
+		steps = dy
+	}
+	for i := 0; i <= steps; i++ {
+		img.Set(x0+(x1-x0)*i/steps, y0+(y1-y0)*i/steps, c)
+	}
+}
+
+// noise source of image for token
+func captchaRand(token string) *mrand.Rand {
+	mac := hmac.New(sha256.New, captchaSeedKey)
+	mac.Write([]byte(token))
+	return mrand.New(mrand.NewSource(int64(binary.LittleEndian.Uint64(mac.Sum(nil)))))
+}
+
+// renders digits with jitter and noise
+func drawCaptcha(answer string, rnd *mrand.Rand) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, captchaWidth, captchaHeight))
+	for y := 0; y < captchaHeight; y++ {
+		for x := 0; x < captchaWidth; x++ {
+			v := uint8(220 + rnd.Intn(36))
+			img.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+
+	step := captchaWidth / (len(answer) + 1)
+	for i := 0; i < len(answer); i++ {
+		g := &captchaGlyphs[answer[i]-'0']
+		c := color.RGBA{uint8(rnd.Intn(120)), uint8(rnd.Intn(120)), uint8(rnd.Intn(120)), 255}
+		ox := step/2 + i*step + rnd.Intn(7) - 3
+		oy := (captchaHeight-7*captchaScale)/2 + rnd.Intn(11) - 5
+		slant := rnd.Intn(3) - 1 // shifts rows sideways for simple shear
+		for row := 0; row < 7; row++ {
+			shift := slant * (row - 3)
+			for col := 0; col < 5; col++ {
+				if g[row][col] != '#' {
+					continue
+				}
+				for sy := 0; sy < captchaScale; sy++ {
+					for sx := 0; sx < captchaScale; sx++ {
+						img.Set(ox+shift+col*captchaScale+sx, oy+row*captchaScale+sy, c)
+					}
+				}
+			}
+		}
+	}
+
+	for i := 0; i < 6; i++ {
+		c := color.RGBA{uint8(rnd.Intn(160)), uint8(rnd.Intn(160)), uint8(rnd.Intn(160)), 255}
+		drawLine(img, rnd.Intn(captchaWidth), rnd.Intn(captchaHeight), rnd.Intn(captchaWidth), rnd.Intn(captchaHeight), c)
+	}
+	for i := 0; i < captchaWidth*captchaHeight/20; i++ {
+		v := uint8(rnd.Intn(256))
+		img.Set(rnd.Intn(captchaWidth), rnd.Intn(captchaHeight), color.RGBA{v, v, v, 255})
+	}
+	return img
+}
+
+// image of challenge: /captcha/{token}.png
+func serveCaptchaImage(w http.ResponseWriter, r *http.Request, name string) {
+	if !strings.HasSuffix(name, ".png") {
+		http.NotFound(w, r)
+		return
+	}
+	token := name[:len(name)-4]
+	answer, ok := captchaAnswer(token)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	// same token must always look the same, otherwise noise could be averaged out
+	var buf bytes.Buffer
+	panicErr(png.Encode(&buf, drawCaptcha(answer, captchaRand(token))))
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf.Bytes())
+}
+
+type jsonCaptcha struct {
+	Token string `json:"token"`
+	Image string `json:"image"`
+}
+
+// new challenge for API clients: /captcha.json
+func renderCaptchaJSON(w http.ResponseWriter, r *http.Request) {
+	token := newCaptcha()
+	execJSON(w, &jsonCaptcha{Token: token, Image: "/captcha/" + token + ".png"})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestDrawLine(t *testing.T) {
+	type lineset struct {
+		x0, y0, x1, y1 int
+	}
+	var tests = [...]lineset{
+		{x0: 5, y0: 5, x1: 5, y1: 5},
+		{x0: 0, y0: 0, x1: 9, y1: 0},
+		{x0: 9, y0: 9, x1: 0, y1: 0},
+		{x0: 3, y0: 0, x1: 3, y1: 9},
+	}
+	c := color.RGBA{1, 2, 3, 255}
+	for i := range tests {
+		l := &tests[i]
+		img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+		drawLine(img, l.x0, l.y0, l.x1, l.y1, c)
+		if img.At(l.x0, l.y0) != c || img.At(l.x1, l.y1) != c {
+			t.Errorf("line %v: endpoints not drawn\n", *l)
+		}
+	}
+}
+
+func TestSolveCaptcha(t *testing.T) {
+	token := newCaptcha()
+	answer, ok := captchaAnswer(token)
+	if !ok || len(answer) != cfg.Captcha.Length {
+		t.Fatalf("fresh token: expected answer of %d digits; got: %q, %v\n", cfg.Captcha.Length, answer, ok)
+	}
+	if solveCaptcha(token+"A", answer) {
+		t.Errorf("altered token: expected rejection\n")
+	}
+	if !solveCaptcha(token, " "+answer+"\n") {
+		t.Errorf("right answer: expected acceptance\n")
+	}
+	if solveCaptcha(token, answer) {
+		t.Errorf("reused token: expected rejection\n")
+	}
+	if _, ok := captchaAnswer(token); ok {
+		t.Errorf("reused token: expected no image\n")
+	}
+
+	token = newCaptcha()
+	answer, _ = captchaAnswer(token)
+	wrong := []byte(answer)
+	wrong[0] = '0' + (wrong[0]-'0'+1)%10
+	if solveCaptcha(token, string(wrong)) {
+		t.Errorf("wrong answer: expected rejection\n")
+	}
+	if solveCaptcha(token, answer) {
+		t.Errorf("token used by wrong answer: expected rejection\n")
+	}
+
+	lifetime := cfg.Captcha.Lifetime
+	cfg.Captcha.Lifetime = -10
+	token = newCaptcha()
+	cfg.Captcha.Lifetime = lifetime
+	if _, _, ok := openCaptcha(token); ok {
+		t.Errorf("expired token: expected rejection\n")
+	}
+}
+
+func TestCaptchaImageStable(t *testing.T) {
+	token := newCaptcha()
+	answer, _ := captchaAnswer(token)
+	a := drawCaptcha(answer, captchaRand(token))
+	b := drawCaptcha(answer, captchaRand(token))
+	for i := range a.Pix {
+		if a.Pix[i] != b.Pix[i] {
+			t.Fatalf("same token drew different images\n")
+		}
+	}
+}
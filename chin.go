@@ -44,6 +44,18 @@ func (HandlerType) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			renderFront(w, r)
 			return
 		}
+		if r.URL.Path == "/boards.json" {
+			renderFrontJSON(w, r)
+			return
+		}
+		if r.URL.Path == "/login" {
+			renderLogin(w, r)
+			return
+		}
+		if r.URL.Path == "/captcha.json" {
+			renderCaptchaJSON(w, r)
+			return
+		}
 
 		board := r.URL.Path[1:]
 
@@ -61,6 +73,22 @@ func (HandlerType) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		case "static":
 			serveFile(w, r, pathStaticSafeFile("", restype))
 			return
+		case "mod":
+			a, ok := requireLogin(w, r)
+			if !ok {
+				return
+			}
+			serveModGet(w, r, a, restype)
+			return
+		case "captcha":
+			serveCaptchaImage(w, r, restype)
+			return
+		}
+
+		// reject unknown boards early
+		if !validBoardName(board) {
+			http.NotFound(w, r)
+			return
 		}
 
 		var subinfo string
@@ -70,7 +98,29 @@ func (HandlerType) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 		switch restype {
 		case "":
-			renderBoard(w, r, board, false)
+			renderBoard(w, r, board, 1, false)
+		case "index.json":
+			renderBoardJSON(w, r, board, 1)
+		case "catalog":
+			renderCatalog(w, r, board)
+		case "log", "log.json":
+			renderBoardLog(w, r, board)
+		case "threads.json":
+			render4chanThreads(w, r, board)
+		case "catalog.json":
+			render4chanCatalog(w, r, board)
+		case "res":
+			// 4chan API style thread: res/{id}.json
+			if !strings.HasSuffix(subinfo, ".json") || strings.IndexByte(subinfo[1:], '/') != -1 {
+				http.NotFound(w, r)
+				return
+			}
+			n, err := strconv.ParseUint(subinfo[1:len(subinfo)-5], 10, 64)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			render4chanThread(w, r, board, n)
 		case "thread":
 			if subinfo == "" || subinfo == "/" {
 				http.Redirect(w, r, "/"+board+"/", http.StatusFound)
@@ -80,12 +130,20 @@ func (HandlerType) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			if i := strings.IndexByte(subinfo, '/'); i != -1 {
 				subinfo = subinfo[:i] // ignore / and anything after it
 			}
+			isjson := strings.HasSuffix(subinfo, ".json")
+			if isjson {
+				subinfo = subinfo[:len(subinfo)-5]
+			}
 			n, err := strconv.ParseUint(subinfo, 10, 64)
 			if err != nil {
 				http.NotFound(w, r)
 				return
 			}
-			renderThread(w, r, board, n, false)
+			if isjson {
+				renderThreadJSON(w, r, board, n)
+			} else {
+				renderThread(w, r, board, n, false)
+			}
 		case "src":
 			if subinfo == "" || subinfo == "/" {
 				http.Redirect(w, r, "/"+board+"/", http.StatusFound)
@@ -112,12 +170,24 @@ func (HandlerType) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			}
 			serveFile(w, r, pathThumbFile(board, subinfo))
 		case "mod":
+			if _, ok := requireBoardMod(w, r, board); !ok {
+				return
+			}
 			if subinfo == "" {
 				http.Redirect(w, r, "/"+board+"/mod/", http.StatusFound)
 				return
 			}
 			if subinfo == "/" {
-				renderBoard(w, r, board, true)
+				page := 1
+				if p := r.FormValue("page"); p != "" {
+					n, err := strconv.ParseUint(p, 10, 31)
+					if err != nil || n == 0 {
+						http.NotFound(w, r)
+						return
+					}
+					page = int(n)
+				}
+				renderBoard(w, r, board, page, true)
 				return
 			}
 			subinfo = subinfo[1:]
@@ -131,22 +201,64 @@ func (HandlerType) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			}
 			renderThread(w, r, board, n, true)
 		default:
-			http.NotFound(w, r)
+			// board index pages: /{board}/2, /{board}/2.json
+			isjson := strings.HasSuffix(restype, ".json")
+			if isjson {
+				restype = restype[:len(restype)-5]
+			}
+			n, err := strconv.ParseUint(restype, 10, 31)
+			if err != nil || n == 0 || subinfo != "" {
+				http.NotFound(w, r)
+				return
+			}
+			if isjson {
+				renderBoardJSON(w, r, board, int(n))
+			} else {
+				renderBoard(w, r, board, int(n), false)
+			}
 		}
 	} else if r.Method == "POST" {
-		board := r.URL.Path[1:]
+		// .json suffix only selects response format
+		board := strings.TrimSuffix(r.URL.Path[1:], ".json")
 		var nfunc string
 		if i := strings.IndexByte(board, '/'); i != -1 {
 			board, nfunc = board[:i], board[i:]
 		}
-		if board == "newboard" && nfunc == "" {
-			postNewBoard(w, r)
-			return
+		if nfunc == "" {
+			switch board {
+			case "login":
+				postLogin(w, r)
+				return
+			case "logout":
+				postLogout(w, r)
+				return
+			case "newboard":
+				if _, ok := requireAdmin(w, r); !ok {
+					return
+				}
+				postNewBoard(w, r)
+				return
+			case "delboard":
+				a, ok := requireAdmin(w, r)
+				if !ok {
+					return
+				}
+				postDelBoard(w, r, a)
+				return
+			}
 		}
 		if nfunc == "" || nfunc == "/" {
 			http.NotFound(w, r)
 			return
 		}
+		if board == "mod" {
+			a, ok := requireLogin(w, r)
+			if !ok {
+				return
+			}
+			serveModPost(w, r, a, nfunc)
+			return
+		}
 		nfunc = nfunc[1:]
 		var tfunc string
 		if i := strings.IndexByte(nfunc, '/'); i != -1 {
@@ -156,6 +268,13 @@ func (HandlerType) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			http.NotFound(w, r)
 			return
 		}
+		var a *adminAccount
+		if nfunc == "mod" {
+			var ok bool
+			if a, ok = requireBoardMod(w, r, board); !ok {
+				return
+			}
+		}
 		if tfunc == "/new" {
 			postNewThread(w, r, board)
 		} else {
@@ -164,7 +283,20 @@ func (HandlerType) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			if i := strings.IndexByte(tfunc, '/'); i != -1 {
 				tfunc, ttfunc = tfunc[:i], tfunc[i:]
 			}
-			if !(ttfunc == "/post" || ttfunc == "/deleted") {
+			if nfunc == "mod" && (ttfunc == "/sticky" || ttfunc == "/locked" || ttfunc == "/cyclical" || ttfunc == "/move") {
+				n, err := strconv.ParseUint(tfunc, 10, 64)
+				if err != nil {
+					http.NotFound(w, r)
+					return
+				}
+				if ttfunc == "/move" {
+					postMoveThread(w, r, a, board, n)
+				} else {
+					postThreadFlag(w, r, board, n, ttfunc[1:])
+				}
+				return
+			}
+			if !(ttfunc == "/post" || ((ttfunc == "/report" || ttfunc == "/delete") && nfunc == "thread") || ((ttfunc == "/deleted" || ttfunc == "/ban") && nfunc == "mod")) {
 				http.NotFound(w, r)
 				return
 			}
@@ -176,9 +308,23 @@ func (HandlerType) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				}
 				postNewPost(w, r, board, n)
 			}
+			if ttfunc == "/report" {
+				postReport(w, r, board)
+			}
+			if ttfunc == "/delete" {
+				postPosterDelete(w, r, board)
+			}
 			if ttfunc == "/deleted" {
 				postDelete(w, r, board)
 			}
+			if ttfunc == "/ban" {
+				// bans are global, so board moderators can't make them
+				if !a.IsAdmin() {
+					reportError(w, r, newReqError(403, errForbidden, "admin role required"))
+					return
+				}
+				postBan(w, r, board)
+			}
 		}
 	} else {
 		http.Error(w, "501 not implemented", 501)
@@ -190,10 +336,12 @@ func init() {
 }
 
 func main() {
+	loadConfig()
+
 	if len(os.Args) < 2 {
 		loadTemplates()
 
-		http.ListenAndServe(":1337", &HandlerType{})
+		panicErr(http.ListenAndServe(cfg.Server.Listen, &HandlerType{}))
 	} else {
 		cmd := os.Args[1]
 		var method string
@@ -213,6 +361,28 @@ func main() {
 			makeThumbs(method, board, file)
 		case "initdb":
 			initDbCmd()
+		case "addadmin":
+			var username, role string
+			if len(os.Args) > 2 {
+				username = os.Args[2]
+			}
+			if len(os.Args) > 3 {
+				role = os.Args[3]
+			}
+			addAdminCmd(username, role)
+		case "boardset":
+			var board string
+			var args []string
+			if len(os.Args) > 2 {
+				board, args = os.Args[2], os.Args[3:]
+			}
+			boardSetCmd(board, args)
+		case "assign", "unassign":
+			var username, board string
+			if len(os.Args) > 3 {
+				username, board = os.Args[2], os.Args[3]
+			}
+			assignBoardCmd(username, board, cmd == "assign")
 		default:
 			fmt.Printf("unknown command: %s\n", cmd)
 		}
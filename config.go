@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// configuration, loaded from JSON file at startup.
+// file location is taken from CHIN_CONFIG, default is chin.json in working dir.
+// individual settings can be overriden by environment variables listed in configEnv
+type configType struct {
+	Server struct {
+		Listen     string
+		TrustProxy bool // take client address from X-Forwarded-For/X-Real-IP
+	}
+	Database struct {
+		Host            string
+		Port            int
+		User            string
+		Password        string
+		Name            string
+		SSLMode         string
+		MaxOpenConns    int
+		MaxIdleConns    int
+		ConnMaxLifetime int // in seconds
+	}
+	Storage struct {
+		BaseDir string // where board files and static files are stored
+	}
+	Thumbs struct {
+		MaxWidth  int
+		MaxHeight int
+		BgOp      string // background color for OP thumbs
+		BgReply   string // background color for reply thumbs
+	}
+	Admin struct {
+		SessionLifetime int // in seconds
+	}
+	Limits struct {
+		MaxImageSize   int64
+		MaxMusicSize   int64
+		MaxFiles       int              // attachments per post, for boards which don't set their own. at least 1
+		AllowedTypes   map[string]int64 // mime type -> max size. if set, replaces default list
+		ThreadsPerPage int              // threads in one board index page
+		PreviewReplies int              // last replies shown for each thread in board index
+		ReportsPerHour int              // reports one address can make per hour, 0 means unlimited
+		DeleteAttempts int              // wrong deletion passwords one address can try per hour, 0 means unlimited
+		// defaults for boards which don't set their own, in seconds. 0 disables
+		ReplyCooldown   int // between posts of same poster
+		ThreadCooldown  int // between threads of same poster
+		DuplicateWindow int // during which poster can't repeat same message
+	}
+	Captcha struct {
+		Length   int // digits in challenge
+		Lifetime int // in seconds
+	}
+}
+
+const defaultConfigFile = "chin.json"
+
+var cfg = defaultConfig()
+
+func defaultConfig() (c configType) {
+	c.Server.Listen = ":1337"
+
+	c.Database.User = "postgres"
+	c.Database.Password = "postgres"
+	c.Database.Name = "chin"
+	c.Database.SSLMode = "disable"
+	c.Database.MaxOpenConns = 16
+	c.Database.MaxIdleConns = 4
+	c.Database.ConnMaxLifetime = 30 * 60
+
+	c.Storage.BaseDir = "files"
+
+	c.Thumbs.MaxWidth = 128
+	c.Thumbs.MaxHeight = 128
+	c.Thumbs.BgOp = "red"
+	c.Thumbs.BgReply = "#D6DAF0"
+
+	c.Admin.SessionLifetime = 24 * 60 * 60
+
+	c.Limits.MaxImageSize = 8 << 20
+	c.Limits.MaxMusicSize = 50 << 20 // :^)
+	c.Limits.MaxFiles = 1
+	c.Limits.ThreadsPerPage = 10
+	c.Limits.PreviewReplies = 5
+	c.Limits.ReportsPerHour = 10
+	c.Limits.DeleteAttempts = 10
+	c.Limits.ReplyCooldown = 15
+	c.Limits.ThreadCooldown = 60
+	c.Limits.DuplicateWindow = 600
+
+	c.Captcha.Length = 6
+	c.Captcha.Lifetime = 10 * 60
+
+	return
+}
+
+var configEnv = []struct {
+	name string
+	ptr  interface{}
+}{
+	{"CHIN_LISTEN", &cfg.Server.Listen},
+	{"CHIN_TRUST_PROXY", &cfg.Server.TrustProxy},
+	{"CHIN_DB_HOST", &cfg.Database.Host},
+	{"CHIN_DB_PORT", &cfg.Database.Port},
+	{"CHIN_DB_USER", &cfg.Database.User},
+	{"CHIN_DB_PASSWORD", &cfg.Database.Password},
+	{"CHIN_DB_NAME", &cfg.Database.Name},
+	{"CHIN_DB_SSLMODE", &cfg.Database.SSLMode},
+	{"CHIN_DB_MAX_OPEN_CONNS", &cfg.Database.MaxOpenConns},
+	{"CHIN_DB_MAX_IDLE_CONNS", &cfg.Database.MaxIdleConns},
+	{"CHIN_DB_CONN_MAX_LIFETIME", &cfg.Database.ConnMaxLifetime},
+	{"CHIN_BASE_DIR", &cfg.Storage.BaseDir},
+	{"CHIN_THUMB_MAX_WIDTH", &cfg.Thumbs.MaxWidth},
+	{"CHIN_THUMB_MAX_HEIGHT", &cfg.Thumbs.MaxHeight},
+	{"CHIN_THUMB_BG_OP", &cfg.Thumbs.BgOp},
+	{"CHIN_THUMB_BG_REPLY", &cfg.Thumbs.BgReply},
+	{"CHIN_SESSION_LIFETIME", &cfg.Admin.SessionLifetime},
+	{"CHIN_MAX_IMAGE_SIZE", &cfg.Limits.MaxImageSize},
+	{"CHIN_MAX_MUSIC_SIZE", &cfg.Limits.MaxMusicSize},
+	{"CHIN_MAX_FILES", &cfg.Limits.MaxFiles},
+	{"CHIN_THREADS_PER_PAGE", &cfg.Limits.ThreadsPerPage},
+	{"CHIN_PREVIEW_REPLIES", &cfg.Limits.PreviewReplies},
+	{"CHIN_REPORTS_PER_HOUR", &cfg.Limits.ReportsPerHour},
+	{"CHIN_DELETE_ATTEMPTS", &cfg.Limits.DeleteAttempts},
+	{"CHIN_REPLY_COOLDOWN", &cfg.Limits.ReplyCooldown},
+	{"CHIN_THREAD_COOLDOWN", &cfg.Limits.ThreadCooldown},
+	{"CHIN_DUPLICATE_WINDOW", &cfg.Limits.DuplicateWindow},
+	{"CHIN_CAPTCHA_LENGTH", &cfg.Captcha.Length},
+	{"CHIN_CAPTCHA_LIFETIME", &cfg.Captcha.Lifetime},
+}
+
+func loadConfigFile(fname string) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewDecoder(f).Decode(&cfg)
+}
+
+func loadConfigEnv() error {
+	for _, e := range configEnv {
+		v, ok := os.LookupEnv(e.name)
+		if !ok {
+			continue
+		}
+		var err error
+		switch p := e.ptr.(type) {
+		case *string:
+			*p = v
+		case *int:
+			*p, err = strconv.Atoi(v)
+		case *int64:
+			*p, err = strconv.ParseInt(v, 10, 64)
+		case *bool:
+			*p, err = strconv.ParseBool(v)
+		}
+		if err != nil {
+			return fmt.Errorf("bad value of %s: %s", e.name, err)
+		}
+	}
+	return nil
+}
+
+func loadConfig() {
+	fname, explicit := os.LookupEnv("CHIN_CONFIG")
+	if !explicit {
+		fname = defaultConfigFile
+	}
+	err := loadConfigFile(fname)
+	if err != nil && (explicit || !os.IsNotExist(err)) {
+		panic(fmt.Errorf("failed loading config %s: %s", fname, err))
+	}
+	panicErr(loadConfigEnv())
+
+	// boards can turn uploads off themselves, server default shouldn't do it by accident
+	if cfg.Limits.MaxFiles < 1 {
+		cfg.Limits.MaxFiles = 1
+	}
+	// empty challenge would be solved by empty answer
+	if cfg.Captcha.Length < 1 {
+		panic(fmt.Errorf("bad captcha length %d: must be at least 1", cfg.Captcha.Length))
+	}
+
+	initAllowedTypes()
+}
+
+// escapes value for use in postgres connection string
+func dsnValue(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `'`, `\'`, -1)
+	return "'" + s + "'"
+}
+
+func configDSN() string {
+	c := &cfg.Database
+	dsn := fmt.Sprintf("user=%s password=%s dbname=%s sslmode=%s", dsnValue(c.User), dsnValue(c.Password), dsnValue(c.Name), dsnValue(c.SSLMode))
+	if c.Host != "" {
+		dsn += " host=" + dsnValue(c.Host)
+	}
+	if c.Port != 0 {
+		dsn += " port=" + strconv.Itoa(c.Port)
+	}
+	return dsn
+}
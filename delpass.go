@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// posters can delete their own posts using password given when posting.
+// if they don't give one, it's generated and kept in cookie
+const (
+	delPassCookie   = "delpass"
+	maxDelPassLen   = 72 // bcrypt doesn't take longer
+	delPassLifetime = 365 * 24 * time.Hour
+	logPosterActor  = "(poster)" // actor of self-deletions in audit log
+)
+
+// bcrypt, as passwords chosen by posters are often weak and shared
+func hashDelPass(password string) string {
+	return hashPassword(password)
+}
+
+func checkDelPass(hash, password string) bool {
+	if hash == "" || password == "" {
+		return false
+	}
+	return checkPassword(hash, password)
+}
+
+// password given in form, or one from cookie, or newly generated one.
+// cookie is updated so that later deletions from same browser work without typing it
+func posterPassword(w http.ResponseWriter, r *http.Request) string {
+	password := r.FormValue("password")
+	if len(password) > maxDelPassLen {
+		password = password[:maxDelPassLen]
+	}
+	if password == "" {
+		if c, err := r.Cookie(delPassCookie); err == nil && c.Value != "" && len(c.Value) <= maxDelPassLen {
+			return c.Value
+		}
+		var b [12]byte
+		_, err := rand.Read(b[:])
+		panicErr(err)
+		password = hex.EncodeToString(b[:])
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     delPassCookie,
+		Value:    password,
+		Path:     "/",
+		Expires:  time.Now().Add(delPassLifetime),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return password
+}
+
+// deletion of post by its author
+func postPosterDelete(w http.ResponseWriter, r *http.Request, board string) {
+	r.ParseForm()
+	spost, ok := r.PostForm["id"]
+	if !ok {
+		reportError(w, r, newReqError(400, errMissingField, "no post id specified"))
+		return
+	}
+	post, err := strconv.ParseUint(spost[0], 10, 64)
+	if err != nil {
+		reportError(w, r, newReqError(400, errBadPostId, "bad post id"))
+		return
+	}
+	password := r.PostFormValue("password")
+	if password == "" {
+		if c, err := r.Cookie(delPassCookie); err == nil {
+			password = c.Value
+		}
+	}
+
+	db := sqlPool()
+
+	if !sqlValidateBoard(db, board) {
+		reportError(w, r, newReqError(404, errBoardNotFound, "board not found"))
+		return
+	}
+
+	ip := clientIP(r)
+	if e := checkDeleteAttempts(ip); e != nil {
+		reportError(w, r, e)
+		return
+	}
+
+	var hash sql.NullString
+	err = boardStmt(db, board, "SELECT delpass FROM %s.posts WHERE id=$1").QueryRow(post).Scan(&hash)
+	if err == sql.ErrNoRows {
+		reportError(w, r, newReqError(404, errPostNotFound, "post not found"))
+		return
+	}
+	panicErr(err)
+	if !hash.Valid || !checkDelPass(hash.String, password) {
+		recordDeleteFailure(ip)
+		reportError(w, r, newReqError(403, errWrongPassword, "wrong password"))
+		return
+	}
+
+	var pr deleteResult
+	pr.FileOnly = r.PostFormValue("fileonly") != ""
+	if pr.FileOnly {
+		if !removePostFile(w, r, &pr.postResult, board, post, logPosterActor, "") {
+			return
+		}
+	} else {
+		if !removePost(w, r, &pr.postResult, board, post, logPosterActor, "") {
+			return
+		}
+	}
+
+	reportResult(w, r, "postdeleted", &pr)
+}
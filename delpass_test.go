@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCheckDelPass(t *testing.T) {
+	hash := hashDelPass("secret")
+	type passset struct {
+		hash     string
+		password string
+		ok       bool
+	}
+	var tests = [...]passset{
+		{hash: hash, password: "secret", ok: true},
+		{hash: hash, password: "Secret", ok: false},
+		{hash: hash, password: "", ok: false},
+		{hash: "", password: "secret", ok: false},
+		{hash: "", password: "", ok: false},
+		{hash: "garbage", password: "secret", ok: false},
+	}
+	for i := range tests {
+		if ok := checkDelPass(tests[i].hash, tests[i].password); ok != tests[i].ok {
+			t.Errorf("checkDelPass(%q, %q): expected: %v; got: %v\n", tests[i].hash, tests[i].password, tests[i].ok, ok)
+		}
+	}
+	if hashDelPass("secret") == hash {
+		t.Errorf("hashes of same password aren't salted\n")
+	}
+}
+
+func TestDeleteAttempts(t *testing.T) {
+	limit := cfg.Limits.DeleteAttempts
+	cfg.Limits.DeleteAttempts = 3
+	defer func() { cfg.Limits.DeleteAttempts = limit }()
+
+	ip := net.ParseIP("2001:db8::1")
+	for i := 0; i < 3; i++ {
+		if e := checkDeleteAttempts(ip); e != nil {
+			t.Fatalf("attempt %d: expected no error; got: %s\n", i+1, e.Message)
+		}
+		recordDeleteFailure(ip)
+	}
+	// same /64 counts as same poster
+	if e := checkDeleteAttempts(net.ParseIP("2001:db8::2")); e == nil || e.Status != 429 {
+		t.Errorf("attempt over limit: expected 429\n")
+	}
+	if e := checkDeleteAttempts(net.ParseIP("2001:db8:1::1")); e != nil {
+		t.Errorf("other address: expected no error; got: %s\n", e.Message)
+	}
+}
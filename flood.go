@@ -0,0 +1,162 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// posting limits of board, in seconds. 0 disables limit
+type floodLimits struct {
+	Reply     int // between posts
+	Thread    int // between new threads
+	Duplicate int // during which identical message can't be posted again
+}
+
+// limits of board, server defaults are used for ones board doesn't set
+func boardFloodLimits(db *sql.DB, board string) (l floodLimits) {
+	l = floodLimits{cfg.Limits.ReplyCooldown, cfg.Limits.ThreadCooldown, cfg.Limits.DuplicateWindow}
+	var reply, thread, dup sql.NullInt64
+	q := "SELECT replycooldown, threadcooldown, dupwindow FROM boards WHERE name=$1"
+	err := sqlStmt(db, q).QueryRow(board).Scan(&reply, &thread, &dup)
+	if err == sql.ErrNoRows {
+		return
+	}
+	panicErr(err)
+	if reply.Valid {
+		l.Reply = int(reply.Int64)
+	}
+	if thread.Valid {
+		l.Thread = int(thread.Int64)
+	}
+	if dup.Valid {
+		l.Duplicate = int(dup.Int64)
+	}
+	return
+}
+
+// addresses treated as single poster. IPv6 users usually get whole /64
+func floodRange(ip net.IP) string {
+	if ip.To4() != nil {
+		return ip.String()
+	}
+	r, _ := banRange(ip, "64")
+	return r
+}
+
+func waitError(wait int64) *reqError {
+	return newReqError(429, errRateLimited, fmt.Sprintf("you must wait %d more seconds before posting", wait))
+}
+
+// checks whether poster waited long enough since their last post or thread
+func checkCooldown(db *sql.DB, board string, ip net.IP, isop bool, l *floodLimits) *reqError {
+	if ip == nil {
+		return nil
+	}
+	cooldown, q := l.Reply, "SELECT MAX(date) FROM %s.posts WHERE ip_addr <<= $1"
+	if isop {
+		cooldown, q = l.Thread, "SELECT MAX(date) FROM %s.posts WHERE ip_addr <<= $1 AND thread IS NULL"
+	}
+	if cooldown <= 0 {
+		return nil
+	}
+	var last sql.NullInt64
+	err := boardStmt(db, board, q).QueryRow(floodRange(ip)).Scan(&last)
+	panicErr(err)
+	if wait := last.Int64 + int64(cooldown) - utcUnixTime(); last.Valid && wait > 0 {
+		return waitError(wait)
+	}
+	return nil
+}
+
+// refuses same message posted again by same poster
+func checkDuplicate(db *sql.DB, board string, p *wPostInfo, l *floodLimits) *reqError {
+	if p.IP == nil || p.Message == "" || l.Duplicate <= 0 {
+		return nil
+	}
+	now := utcUnixTime()
+	var last sql.NullInt64
+	q := "SELECT MAX(date) FROM %s.posts WHERE ip_addr <<= $1 AND message=$2 AND date > $3"
+	err := boardStmt(db, board, q).QueryRow(floodRange(p.IP), p.Message, now-int64(l.Duplicate)).Scan(&last)
+	panicErr(err)
+	if last.Valid {
+		return newReqError(429, errDuplicate, fmt.Sprintf("identical message was already posted, you must wait %d more seconds to post it again", last.Int64+int64(l.Duplicate)-now))
+	}
+	return nil
+}
+
+// counts of actions per address range, over hour long windows. kept in memory,
+// losing them on restart is harmless
+const countWindow = 60 * 60 // seconds
+
+type windowCount struct {
+	count int
+	start int64 // when window began
+}
+
+type hourlyCounter struct {
+	sync.Mutex
+	m         map[string]*windowCount
+	lastSweep int64
+}
+
+func newHourlyCounter() *hourlyCounter {
+	return &hourlyCounter{m: make(map[string]*windowCount)}
+}
+
+// seconds until address can act again if it reached limit, 0 if it can now
+func (c *hourlyCounter) wait(ip net.IP, limit int) int64 {
+	if ip == nil || limit <= 0 {
+		return 0
+	}
+	now := utcUnixTime()
+	c.Lock()
+	defer c.Unlock()
+	f := c.m[floodRange(ip)]
+	if f != nil && f.count >= limit {
+		if wait := f.start + countWindow - now; wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+func (c *hourlyCounter) add(ip net.IP) {
+	if ip == nil {
+		return
+	}
+	now := utcUnixTime()
+	c.Lock()
+	defer c.Unlock()
+	if now-c.lastSweep >= countWindow {
+		for k, f := range c.m {
+			if now-f.start >= countWindow {
+				delete(c.m, k)
+			}
+		}
+		c.lastSweep = now
+	}
+	key := floodRange(ip)
+	f := c.m[key]
+	if f == nil || now-f.start >= countWindow {
+		f = &windowCount{start: now}
+		c.m[key] = f
+	}
+	f.count++
+}
+
+// failed deletion password attempts, so that passwords of other posters can't be guessed
+var deleteFailures = newHourlyCounter()
+
+// refuses further attempts once poster failed too many times in last hour
+func checkDeleteAttempts(ip net.IP) *reqError {
+	if wait := deleteFailures.wait(ip, cfg.Limits.DeleteAttempts); wait > 0 {
+		return newReqError(429, errRateLimited, fmt.Sprintf("too many wrong passwords, try again in %d seconds", wait))
+	}
+	return nil
+}
+
+func recordDeleteFailure(ip net.IP) {
+	deleteFailures.add(ip)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// global moderation pages, under /mod/. login is already checked by caller
+
+func serveModGet(w http.ResponseWriter, r *http.Request, a *adminAccount, page string) {
+	page = strings.TrimSuffix(page, ".json")
+	switch page {
+	case "bans":
+		// bans are global, so board moderators can't manage them
+		if !a.IsAdmin() {
+			reportError(w, r, newReqError(403, errForbidden, "admin role required"))
+			return
+		}
+		renderBans(w, r)
+	case "log":
+		if !a.IsAdmin() {
+			reportError(w, r, newReqError(403, errForbidden, "admin role required"))
+			return
+		}
+		renderModLog(w, r)
+	case "reports":
+		renderReports(w, r, a)
+	case "settings":
+		if !a.IsAdmin() {
+			reportError(w, r, newReqError(403, errForbidden, "admin role required"))
+			return
+		}
+		renderBoardSettings(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func serveModPost(w http.ResponseWriter, r *http.Request, a *adminAccount, action string) {
+	switch action {
+	case "/reports/dismiss":
+		postReportsDismiss(w, r, a)
+	case "/settings":
+		if !a.IsAdmin() {
+			reportError(w, r, newReqError(403, errForbidden, "admin role required"))
+			return
+		}
+		postBoardSettings(w, r, a)
+	case "/bans/edit":
+		if !a.IsAdmin() {
+			reportError(w, r, newReqError(403, errForbidden, "admin role required"))
+			return
+		}
+		postBansEdit(w, r)
+	case "/bans/lift":
+		if !a.IsAdmin() {
+			reportError(w, r, newReqError(403, errForbidden, "admin role required"))
+			return
+		}
+		postBansLift(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// audit log actions
+const (
+	logDeletePost   = "delete_post"
+	logDeleteThread = "delete_thread"
+	logDeleteFile   = "delete_file"
+	logMoveThread   = "move_thread"
+	logPruneThread  = "prune_thread" // thread fell off last page
+	logPrunePost    = "prune_post"   // old reply dropped from cyclical thread
+	logDeleteBoard  = "delete_board"
+	logEditBoard    = "edit_board"
+	logBan          = "ban"
+	logEditBan      = "edit_ban"
+	logLiftBan      = "lift_ban"
+	logSticky       = "sticky"
+	logUnsticky     = "unsticky"
+	logLock         = "lock"
+	logUnlock       = "unlock"
+	logCyclical     = "cyclical"
+	logUncyclical   = "uncyclical"
+)
+
+const logEntriesPerPage = 100
+
+type logEntry struct {
+	Date   int64  `json:"date"`
+	Actor  string `json:"actor,omitempty"` // account which did it, empty for automatic actions
+	Action string `json:"action"`
+	Board  string `json:"board,omitempty"`
+	Thread uint64 `json:"thread,omitempty"`
+	Post   uint64 `json:"post,omitempty"`
+	Target string `json:"target,omitempty"` // address or range for ban actions
+	Reason string `json:"reason,omitempty"`
+}
+
+func (e *logEntry) StrDate() string {
+	return time.Unix(e.Date, 0).UTC().Format("2006-01-02 15:04:05 UTC")
+}
+
+func (e *logEntry) StrActor() string {
+	if e.Actor == "" {
+		return "system"
+	}
+	return e.Actor
+}
+
+func nullUint(n uint64) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(n), Valid: n != 0}
+}
+
+func nullStr(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func logAction(db *sql.DB, e *logEntry) {
+	if e.Date == 0 {
+		e.Date = utcUnixTime()
+	}
+	q := `INSERT INTO mod_log (date, actor, action, board, thread, post, target, reason)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err := sqlStmt(db, q).Exec(e.Date, nullStr(e.Actor), e.Action, nullStr(e.Board), nullUint(e.Thread), nullUint(e.Post), nullStr(e.Target), e.Reason)
+	panicErr(err)
+}
+
+// name of account doing request, for log entries
+func logActor(r *http.Request) string {
+	if a := sessionAccount(sqlPool(), r); a != nil {
+		return a.Name
+	}
+	return ""
+}
+
+// loads page of log entries, newest first. board "" means all boards
+func inputLog(db *sql.DB, board string, page int) (entries []logEntry) {
+	q := `SELECT date, actor, action, board, thread, post, target, reason FROM mod_log
+	WHERE $1 = '' OR board = $1
+	ORDER BY id DESC
+	LIMIT $2 OFFSET $3`
+	rows, err := sqlStmt(db, q).Query(board, logEntriesPerPage, (page-1)*logEntriesPerPage)
+	panicErr(err)
+	defer rows.Close()
+	for rows.Next() {
+		var e logEntry
+		var actor, eboard, target sql.NullString
+		var thread, post sql.NullInt64
+		err = rows.Scan(&e.Date, &actor, &e.Action, &eboard, &thread, &post, &target, &e.Reason)
+		panicErr(err)
+		e.Actor, e.Board, e.Target = actor.String, eboard.String, target.String
+		e.Thread, e.Post = uint64(thread.Int64), uint64(post.Int64)
+		entries = append(entries, e)
+	}
+	return
+}
+
+type logInfo struct {
+	Board   string // empty if log covers all boards
+	Public  bool   // public view hides moderators and addresses
+	Page    int
+	Entries []logEntry
+}
+
+func (l *logInfo) HasNext() bool {
+	return len(l.Entries) == logEntriesPerPage
+}
+
+func (l *logInfo) NextPage() int {
+	return l.Page + 1
+}
+
+func logPage(r *http.Request) (int, bool) {
+	p := r.FormValue("page")
+	if p == "" {
+		return 1, true
+	}
+	n, err := strconv.ParseUint(p, 10, 31)
+	if err != nil || n == 0 {
+		return 0, false
+	}
+	return int(n), true
+}
+
+// full log for admins, optionally filtered by board
+func renderModLog(w http.ResponseWriter, r *http.Request) {
+	page, ok := logPage(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	board := r.FormValue("board")
+	if board != "" && !validBoardName(board) {
+		reportError(w, r, newReqError(400, errBadRequest, "invalid board name"))
+		return
+	}
+
+	li := logInfo{Board: board, Page: page}
+	li.Entries = inputLog(sqlPool(), board, page)
+
+	if wantJSON(r) {
+		execJSON(w, li.Entries)
+	} else {
+		execTemplate(w, "log", &li)
+	}
+}
+
+// per-board log, if board has it enabled
+func renderBoardLog(w http.ResponseWriter, r *http.Request, board string) {
+	page, ok := logPage(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	db := sqlPool()
+
+	var public bool
+	err := sqlStmt(db, "SELECT publiclog FROM boards WHERE name=$1").QueryRow(board).Scan(&public)
+	if err == sql.ErrNoRows || (err == nil && !public) {
+		http.NotFound(w, r)
+		return
+	}
+	panicErr(err)
+
+	li := logInfo{Board: board, Public: true, Page: page}
+	li.Entries = inputLog(db, board, page)
+	for i := range li.Entries {
+		li.Entries[i].Actor = ""
+		li.Entries[i].Target = ""
+	}
+
+	if wantJSON(r) {
+		execJSON(w, li.Entries)
+	} else {
+		execTemplate(w, "log", &li)
+	}
+}
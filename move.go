@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// rewrites post references in message of moved post.
+// >>N of posts which were moved together point to their new ids,
+// other >>N become crosslinks to board thread was moved from
+func rewriteRefs(msg, from, to string, ids map[uint64]uint64) string {
+	b := []byte(msg)
+	var w bytes.Buffer
+	src, last := 0, 0
+	for src < len(b) {
+		if b[src] != '>' {
+			src++
+			continue
+		}
+		var board string
+		var post uint64
+		var end int
+		if checkCrossPattern(b, src, &end, &board, &post) {
+			if board == from && post != 0 {
+				if np, ok := ids[post]; ok {
+					w.Write(b[last:src])
+					fmt.Fprintf(&w, ">>>/%s/%d", to, np)
+					last = end
+				}
+			}
+			src = end
+		} else if checkLinkPattern(b, src, &end, &post) {
+			w.Write(b[last:src])
+			if np, ok := ids[post]; ok {
+				fmt.Fprintf(&w, ">>%d", np)
+			} else {
+				fmt.Fprintf(&w, ">>>/%s/%d", from, post)
+			}
+			src = end
+			last = end
+		} else {
+			src++
+		}
+	}
+	w.Write(b[last:])
+	return w.String()
+}
+
+// where post was moved to, if it was
+func sqlFindMoved(db *sql.DB, board string, post uint64) (nboard string, nthread uint64, ok bool) {
+	q := "SELECT new_board, new_thread FROM moved_posts WHERE board=$1 AND post=$2"
+	err := sqlStmt(db, q).QueryRow(board, post).Scan(&nboard, &nthread)
+	if err == sql.ErrNoRows {
+		return "", 0, false
+	}
+	panicErr(err)
+	return nboard, nthread, true
+}
+
+type movedPost struct {
+	id       uint64
+	name     string
+	trip     string
+	subject  string
+	email    string
+	date     int64
+	message  string
+	file     string
+	original string
+	thumb    string
+	ipaddr   sql.NullString
+	banned   bool
+	delpass  sql.NullString
+	extra    []postFile // attachments from post_files
+}
+
+// moves thread with all its posts to another board. posts get new ids there.
+// returns new thread id, or false if thread doesn't exist
+func moveThread(db *sql.DB, from, to string, thread uint64) (uint64, bool) {
+	tx, err := db.Begin()
+	panicErr(err)
+	defer tx.Rollback()
+
+	var t threadInfo
+	var bumpnum int
+	q := "SELECT bump, bumpnum, sticky, locked, cyclical FROM %s.threads WHERE id=$1 FOR UPDATE"
+	err = tx.Stmt(boardStmt(db, from, q)).QueryRow(thread).Scan(&t.Bump, &bumpnum, &t.Sticky, &t.Locked, &t.Cyclical)
+	if err == sql.ErrNoRows {
+		return 0, false
+	}
+	panicErr(err)
+
+	q = `SELECT id, name, trip, subject, email, date, message, file, original, thumb, ip_addr, banned, delpass
+	FROM %s.posts WHERE id=$1 OR thread=$1 ORDER BY id ASC`
+	rows, err := tx.Stmt(boardStmt(db, from, q)).Query(thread)
+	panicErr(err)
+	defer rows.Close()
+	var posts []movedPost
+	for rows.Next() {
+		var p movedPost
+		err = rows.Scan(&p.id, &p.name, &p.trip, &p.subject, &p.email, &p.date, &p.message, &p.file, &p.original, &p.thumb, &p.ipaddr, &p.banned, &p.delpass)
+		panicErr(err)
+		posts = append(posts, p)
+	}
+	if len(posts) == 0 || posts[0].id != thread {
+		return 0, false // thread without OP
+	}
+
+	q = "SELECT idx, file, original, thumb FROM %s.post_files WHERE post=$1 ORDER BY idx ASC"
+	sel := tx.Stmt(boardStmt(db, from, q))
+	for i := range posts {
+		rows, err := sel.Query(posts[i].id)
+		panicErr(err)
+		defer rows.Close()
+		for rows.Next() {
+			var idx int
+			var f postFile
+			err = rows.Scan(&idx, &f.File, &f.Original, &f.Thumb)
+			panicErr(err)
+			posts[i].extra = append(posts[i].extra, f)
+		}
+	}
+
+	ids := make(map[uint64]uint64, len(posts))
+	var nthread uint64
+	q = `INSERT INTO %s.posts (thread, name, trip, subject, email, date, message, file, original, thumb, ip_addr, banned, delpass)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING id`
+	ins := tx.Stmt(boardStmt(db, to, q))
+	insf := tx.Stmt(boardStmt(db, to, "INSERT INTO %s.post_files (post, idx, file, original, thumb) VALUES ($1, $2, $3, $4, $5)"))
+	for i := range posts {
+		p := &posts[i]
+		var pthread sql.NullInt64
+		if i != 0 {
+			pthread = sql.NullInt64{Int64: int64(nthread), Valid: true}
+		}
+		var id uint64
+		err = ins.QueryRow(pthread, p.name, p.trip, p.subject, p.email, p.date, p.message, p.file, p.original, p.thumb, p.ipaddr, p.banned, p.delpass).Scan(&id)
+		panicErr(err)
+		if i == 0 {
+			nthread = id
+		}
+		ids[p.id] = id
+		for j := range p.extra {
+			_, err = insf.Exec(id, j+1, p.extra[j].File, p.extra[j].Original, p.extra[j].Thumb)
+			panicErr(err)
+		}
+	}
+
+	q = "INSERT INTO %s.threads (id, bump, bumpnum, sticky, locked, cyclical) VALUES ($1, $2, $3, $4, $5, $6)"
+	_, err = tx.Stmt(boardStmt(db, to, q)).Exec(nthread, t.Bump, bumpnum, t.Sticky, t.Locked, t.Cyclical)
+	panicErr(err)
+
+	// messages can only be rewritten once all new ids are known
+	upd := tx.Stmt(boardStmt(db, to, "UPDATE %s.posts SET message=$2 WHERE id=$1"))
+	for i := range posts {
+		msg := rewriteRefs(posts[i].message, from, to, ids)
+		if msg != posts[i].message {
+			_, err = upd.Exec(ids[posts[i].id], msg)
+			panicErr(err)
+		}
+	}
+
+	// thread row is locked, so replies can't be added meanwhile (see postNewPost). only copied posts are deleted anyway
+	oldIds := make([]int64, len(posts))
+	for i := range posts {
+		oldIds[i] = int64(posts[i].id)
+	}
+	_, err = tx.Stmt(boardStmt(db, from, "DELETE FROM %s.post_files WHERE post = ANY($1)")).Exec(pq.Array(oldIds))
+	panicErr(err)
+	_, err = tx.Stmt(boardStmt(db, from, "DELETE FROM %s.posts WHERE id = ANY($1)")).Exec(pq.Array(oldIds))
+	panicErr(err)
+	_, err = tx.Stmt(boardStmt(db, from, "DELETE FROM %s.threads WHERE id=$1")).Exec(thread)
+	panicErr(err)
+
+	// keep old URLs working, including ones of posts which were moved before
+	fwd := tx.Stmt(sqlStmt(db, "UPDATE moved_posts SET new_board=$3, new_thread=$4, new_post=$5 WHERE new_board=$1 AND new_post=$2"))
+	mov := tx.Stmt(sqlStmt(db, `INSERT INTO moved_posts (board, post, new_board, new_thread, new_post) VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (board, post) DO UPDATE SET new_board = EXCLUDED.new_board, new_thread = EXCLUDED.new_thread, new_post = EXCLUDED.new_post`))
+	rep := tx.Stmt(sqlStmt(db, "DELETE FROM reports WHERE board=$1 AND post=$2"))
+	for i := range posts {
+		id := posts[i].id
+		_, err = fwd.Exec(from, id, to, nthread, ids[id])
+		panicErr(err)
+		_, err = mov.Exec(from, id, to, nthread, ids[id])
+		panicErr(err)
+		_, err = rep.Exec(from, id)
+		panicErr(err)
+	}
+
+	panicErr(tx.Commit())
+
+	for i := range posts {
+		moveFiles(from, to, posts[i].file, posts[i].thumb)
+		for _, f := range posts[i].extra {
+			moveFiles(from, to, f.File, f.Thumb)
+		}
+	}
+
+	// target board may now have more threads than it allows
+	var maxthreads sql.NullInt64
+	err = sqlStmt(db, "SELECT maxthreads FROM boards WHERE name=$1").QueryRow(to).Scan(&maxthreads)
+	panicErr(err)
+	if maxthreads.Valid && maxthreads.Int64 != 0 {
+		pruneExcessThreads(db, to, int(maxthreads.Int64))
+	}
+
+	return nthread, true
+}
+
+func moveFiles(from, to, fname, tname string) {
+	if fname != "" && fname[0] != '/' {
+		if err := os.Rename(pathSrcFile(from, fname), pathSrcFile(to, fname)); err != nil {
+			fmt.Printf("warning: failed moving file: %s\n", err)
+		}
+	}
+	if tname != "" && tname[0] != '/' {
+		if err := os.Rename(pathThumbFile(from, tname), pathThumbFile(to, tname)); err != nil {
+			fmt.Printf("warning: failed moving thumb: %s\n", err)
+		}
+	}
+}
+
+type moveResult struct {
+	postResult
+	NewBoard  string `json:"new_board"`
+	NewThread uint64 `json:"new_thread"`
+}
+
+func postMoveThread(w http.ResponseWriter, r *http.Request, a *adminAccount, board string, thread uint64) {
+	r.ParseForm()
+	to := r.PostFormValue("to")
+
+	db := sqlPool()
+
+	if !sqlValidateBoard(db, board) || !sqlValidateBoard(db, to) {
+		reportError(w, r, newReqError(404, errBoardNotFound, "board not found"))
+		return
+	}
+	if to == board {
+		reportError(w, r, newReqError(400, errBadRequest, "thread is already on this board"))
+		return
+	}
+	if !a.mayModerate(db, to) {
+		reportError(w, r, newReqError(403, errForbidden, "not allowed to moderate target board"))
+		return
+	}
+
+	nthread, ok := moveThread(db, board, to, thread)
+	if !ok {
+		reportError(w, r, newReqError(404, errThreadNotFound, "thread not found"))
+		return
+	}
+
+	reason := fmt.Sprintf("moved to /%s/%d", to, nthread)
+	if s := r.PostFormValue("reason"); s != "" {
+		reason += ": " + s
+	}
+	logAction(db, &logEntry{Actor: a.Name, Action: logMoveThread, Board: board, Thread: thread, Post: thread, Reason: reason})
+
+	mr := moveResult{postResult: postResult{Board: board, Thread: thread, Post: thread}, NewBoard: to, NewThread: nthread}
+	reportResult(w, r, "moved", &mr)
+}
+
+// redirects request for thread which was moved to /{board}/{restype}/{thread}{suffix}
+// on its new board. returns false if thread wasn't moved
+func redirectMoved(w http.ResponseWriter, r *http.Request, board string, thread uint64, restype, suffix string) bool {
+	if !validBoardName(board) {
+		return false
+	}
+	nboard, nthread, ok := sqlFindMoved(sqlPool(), board, thread)
+	if !ok {
+		return false
+	}
+	http.Redirect(w, r, "/"+nboard+"/"+restype+"/"+strconv.FormatUint(nthread, 10)+suffix, http.StatusMovedPermanently)
+	return true
+}
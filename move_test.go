@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestRewriteRefs(t *testing.T) {
+	ids := map[uint64]uint64{10: 100, 11: 101}
+	var tests = [...]struct{ src, dst string }{
+		{src: "", dst: ""},
+		{src: "no refs here", dst: "no refs here"},
+		{src: ">>10\nhi", dst: ">>100\nhi"},
+		{src: ">>11 and >>10", dst: ">>101 and >>100"},
+		{src: ">>5", dst: ">>>/a/5"},
+		{src: ">>>/a/11", dst: ">>>/b/101"},
+		{src: ">>>/a/5", dst: ">>>/a/5"},
+		{src: ">>>/c/10", dst: ">>>/c/10"},
+		{src: ">greentext >>10", dst: ">greentext >>100"},
+		{src: ">>>10", dst: ">>>100"},
+	}
+	for i := range tests {
+		dst := rewriteRefs(tests[i].src, "a", "b", ids)
+		if dst != tests[i].dst {
+			t.Errorf("%q: expected: %q; got: %q\n", tests[i].src, tests[i].dst, dst)
+		}
+	}
+}
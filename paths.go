@@ -3,16 +3,16 @@ package main
 import "path"
 
 func pathBaseDir() string {
-	return "files"
+	return cfg.Storage.BaseDir
 }
 
 func pathBoardDir(board string) string {
-	return "files/" + board
+	return pathBaseDir() + "/" + board
 }
 
 // src - where received original files are stored
 func pathSrcDir(board string) string {
-	return "files/" + board + "/src"
+	return pathBoardDir(board) + "/src"
 }
 func pathSrcFile(board, file string) string {
 	return pathSrcDir(board) + "/" + file
@@ -20,7 +20,7 @@ func pathSrcFile(board, file string) string {
 
 // thumb - where generated thumbnails are stored
 func pathThumbDir(board string) string {
-	return "files/" + board + "/thumb"
+	return pathBoardDir(board) + "/thumb"
 }
 func pathThumbFile(board, file string) string {
 	return pathThumbDir(board) + "/" + file
@@ -29,9 +29,9 @@ func pathThumbFile(board, file string) string {
 // static - where static (non-changing images, css, etc) files are stored
 func pathStaticDir(board string) string {
 	if board == "" {
-		return "files/static"
+		return pathBaseDir() + "/static"
 	} else {
-		return "files/" + board + "/static"
+		return pathBoardDir(board) + "/static"
 	}
 }
 func pathStaticFile(board, file string) string {
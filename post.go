@@ -5,29 +5,37 @@ import (
 	"fmt"
 	"io"
 	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
-const (
-	maxImageSize = 8 << 20
-	maxMusicSize = 50 << 20 // :^)
-)
-
 // nice place to also include file sizes
-var allowedTypes = map[string]int64{
-	"image/gif":  maxImageSize,
-	"image/jpeg": maxImageSize,
-	"image/png":  maxImageSize,
-	"image/bmp":  maxImageSize,
-	"audio/mpeg": maxMusicSize,
-	"audio/ogg":  maxMusicSize,
-	"audio/flac": maxMusicSize,
+var allowedTypes map[string]int64
+
+// fills allowedTypes from config
+func initAllowedTypes() {
+	if len(cfg.Limits.AllowedTypes) != 0 {
+		allowedTypes = cfg.Limits.AllowedTypes
+		return
+	}
+	maxImageSize, maxMusicSize := cfg.Limits.MaxImageSize, cfg.Limits.MaxMusicSize
+	allowedTypes = map[string]int64{
+		"image/gif":  maxImageSize,
+		"image/jpeg": maxImageSize,
+		"image/png":  maxImageSize,
+		"image/bmp":  maxImageSize,
+		"audio/mpeg": maxMusicSize,
+		"audio/ogg":  maxMusicSize,
+		"audio/flac": maxMusicSize,
+	}
 }
 
 // add our own mime stuff since golang's parser erroreusly overwrites image/bmp with image/x-ms-bmp
@@ -61,7 +69,10 @@ func utcUnixTime() int64 {
 }
 
 type newBoardInfo struct {
-	Name, Desc, Info string
+	Name      string `json:"name"`
+	Desc      string `json:"description"`
+	Info      string `json:"info"`
+	PublicLog bool   `json:"publiclog"` // whether moderation log of board is visible to everyone
 }
 
 func initDatabase(db *sql.DB) {
@@ -71,29 +82,156 @@ func initDatabase(db *sql.DB) {
 	panicErr(err)
 
 	create_q := `CREATE TABLE IF NOT EXISTS boards (
-		name        text    PRIMARY KEY,
-		description text    NOT NULL,
-		info        text    NOT NULL,
-		maxthreads  integer,
-		bumplimit   integer
+		name           text    PRIMARY KEY,
+		description    text    NOT NULL,
+		info           text    NOT NULL,
+		maxthreads     integer,
+		bumplimit      integer,
+		publiclog      boolean NOT NULL DEFAULT false,
+		replycooldown  integer,
+		threadcooldown integer,
+		dupwindow      integer,
+		captcha        boolean NOT NULL DEFAULT false,
+		maxfiles       integer
 	)`
 	stmt, err := db.Prepare(create_q)
 	panicErr(err)
 	_, err = stmt.Exec()
 	panicErr(err)
 
+	create_q = `ALTER TABLE boards
+		ADD COLUMN IF NOT EXISTS publiclog      boolean NOT NULL DEFAULT false,
+		ADD COLUMN IF NOT EXISTS replycooldown  integer,
+		ADD COLUMN IF NOT EXISTS threadcooldown integer,
+		ADD COLUMN IF NOT EXISTS dupwindow      integer,
+		ADD COLUMN IF NOT EXISTS captcha        boolean NOT NULL DEFAULT false,
+		ADD COLUMN IF NOT EXISTS maxfiles       integer`
+	stmt, err = db.Prepare(create_q)
+	panicErr(err)
+	_, err = stmt.Exec()
+	panicErr(err)
+
+	// audit log of moderation actions. board is not a reference so that
+	// entries outlive deleted boards
+	create_q = `CREATE TABLE IF NOT EXISTS mod_log (
+		id     bigserial PRIMARY KEY,
+		date   bigint    NOT NULL,
+		actor  text,
+		action text      NOT NULL,
+		board  text,
+		thread bigint,
+		post   bigint,
+		target inet,
+		reason text      NOT NULL
+	)`
+	stmt, err = db.Prepare(create_q)
+	panicErr(err)
+	_, err = stmt.Exec()
+	panicErr(err)
+
+	create_q = `CREATE INDEX IF NOT EXISTS mod_log_board_idx ON mod_log (board)`
+	stmt, err = db.Prepare(create_q)
+	panicErr(err)
+	_, err = stmt.Exec()
+	panicErr(err)
+
+	// where moved posts went, so that old links keep working
+	create_q = `CREATE TABLE IF NOT EXISTS moved_posts (
+		board      text   REFERENCES boards ON DELETE CASCADE,
+		post       bigint,
+		new_board  text   NOT NULL,
+		new_thread bigint NOT NULL,
+		new_post   bigint NOT NULL,
+		PRIMARY KEY (board, post)
+	)`
+	stmt, err = db.Prepare(create_q)
+	panicErr(err)
+	_, err = stmt.Exec()
+	panicErr(err)
+
+	// posts reported by users, waiting for moderator
+	create_q = `CREATE TABLE IF NOT EXISTS reports (
+		id       bigserial PRIMARY KEY,
+		board    text      NOT NULL REFERENCES boards ON DELETE CASCADE,
+		post     bigint    NOT NULL,
+		category text      NOT NULL,
+		comment  text      NOT NULL,
+		ip_addr  inet,
+		date     bigint    NOT NULL,
+		UNIQUE (board, post, ip_addr)
+	)`
+	stmt, err = db.Prepare(create_q)
+	panicErr(err)
+	_, err = stmt.Exec()
+	panicErr(err)
+	// unique constraint doesn't catch repeated reports without address, as NULLs differ.
+	// ones which slipped in before have to go for index to be created
+	create_q = `DELETE FROM reports AS a USING reports AS b
+	WHERE a.ip_addr IS NULL AND b.ip_addr IS NULL AND a.board = b.board AND a.post = b.post AND a.id > b.id`
+	stmt, err = db.Prepare(create_q)
+	panicErr(err)
+	_, err = stmt.Exec()
+	panicErr(err)
+
+	create_q = `CREATE UNIQUE INDEX IF NOT EXISTS reports_noaddr_idx ON reports (board, post) WHERE ip_addr IS NULL`
+	stmt, err = db.Prepare(create_q)
+	panicErr(err)
+	_, err = stmt.Exec()
+	panicErr(err)
+
 	create_q = `CREATE TABLE IF NOT EXISTS ip_bans (
-		ip_addr inet PRIMARY KEY,
-		reason  text NOT NULL
+		ip_addr inet   PRIMARY KEY,
+		reason  text   NOT NULL,
+		date    bigint,
+		expires bigint
 	)`
 	stmt, err = db.Prepare(create_q)
 	panicErr(err)
 	_, err = stmt.Exec()
 	panicErr(err)
 
+	// upgrade tables created by older versions
+	create_q = `ALTER TABLE ip_bans
+		ADD COLUMN IF NOT EXISTS date    bigint,
+		ADD COLUMN IF NOT EXISTS expires bigint`
+	stmt, err = db.Prepare(create_q)
+	panicErr(err)
+	_, err = stmt.Exec()
+	panicErr(err)
+
 	create_q = `CREATE TABLE IF NOT EXISTS admins (
 		username text PRIMARY KEY,
-		password text NOT NULL
+		password text NOT NULL,
+		role     text NOT NULL DEFAULT 'admin'
+	)`
+	stmt, err = db.Prepare(create_q)
+	panicErr(err)
+	_, err = stmt.Exec()
+	panicErr(err)
+
+	// accounts made before roles existed were all admins
+	create_q = `ALTER TABLE admins
+		ADD COLUMN IF NOT EXISTS role text NOT NULL DEFAULT 'admin'`
+	stmt, err = db.Prepare(create_q)
+	panicErr(err)
+	_, err = stmt.Exec()
+	panicErr(err)
+
+	// boards moderators are allowed to act on
+	create_q = `CREATE TABLE IF NOT EXISTS admin_boards (
+		username text REFERENCES admins ON DELETE CASCADE,
+		board    text REFERENCES boards ON DELETE CASCADE,
+		PRIMARY KEY (username, board)
+	)`
+	stmt, err = db.Prepare(create_q)
+	panicErr(err)
+	_, err = stmt.Exec()
+	panicErr(err)
+
+	create_q = `CREATE TABLE IF NOT EXISTS admin_sessions (
+		id       text   PRIMARY KEY,
+		username text   NOT NULL REFERENCES admins ON DELETE CASCADE,
+		expires  bigint NOT NULL
 	)`
 	stmt, err = db.Prepare(create_q)
 	panicErr(err)
@@ -101,37 +239,44 @@ func initDatabase(db *sql.DB) {
 	panicErr(err)
 
 	// only these tables so far...
+
+	upgradeBoards(db)
 }
 
 func initDbCmd() {
 	fmt.Print("initialising database...")
 
-	db := openSQL()
-	defer db.Close()
+	db := sqlPool()
 
 	initDatabase(db)
 
 	fmt.Print(" done.\n")
 }
 
+// names which have special meaning in URL paths
+var reservedBoardNames = map[string]bool{
+	"static":   true,
+	"mod":      true,
+	"newboard": true,
+	"delboard": true,
+	"login":    true,
+	"logout":   true,
+	"captcha":  true,
+}
+
+var boardNameRegexp = regexp.MustCompile("^[a-z0-9]{1,10}$")
+
 func validBoardName(name string) bool {
-	ok, _ := regexp.MatchString("^[a-z0-9]{1,10}$", name)
-	if !ok {
-		return false
-	}
-	switch name {
-	case "static":
-	case "mod":
-		return false
-	}
-	return true
+	return boardNameRegexp.MatchString(name) && !reservedBoardNames[name]
 }
 
 func makeNewBoard(db *sql.DB, dbi *newBoardInfo) {
-	// prepare schema
-	stmt, err := db.Prepare("CREATE SCHEMA IF NOT EXISTS $1")
+	schema := boardSchema(dbi.Name)
+
+	// prepare schema. identifiers can't be passed as parameters
+	stmt, err := db.Prepare("CREATE SCHEMA IF NOT EXISTS " + schema)
 	panicErr(err)
-	_, err = stmt.Exec(dbi.Name) // result isn't very meaningful for us, we check err regardless
+	_, err = stmt.Exec() // result isn't very meaningful for us, we check err regardless
 	panicErr(err)
 
 	// prepare tables
@@ -147,29 +292,36 @@ func makeNewBoard(db *sql.DB, dbi *newBoardInfo) {
 		file     text      NOT NULL,
 		original text      NOT NULL,
 		thumb    text      NOT NULL,
-		ip_addr  inet
+		ip_addr  inet,
+		banned   boolean   NOT NULL DEFAULT false,
+		delpass  text
 	)`
-	stmt, err = db.Prepare(fmt.Sprintf(create_q, dbi.Name))
+	stmt, err = db.Prepare(fmt.Sprintf(create_q, schema))
 	panicErr(err)
 	_, err = stmt.Exec()
 	panicErr(err)
 
 	create_q = `CREATE INDEX ON %s.posts (thread)`
-	stmt, err = db.Prepare(fmt.Sprintf(create_q, dbi.Name))
+	stmt, err = db.Prepare(fmt.Sprintf(create_q, schema))
 	panicErr(err)
 	_, err = stmt.Exec()
 	panicErr(err)
 
 	create_q = `CREATE TABLE IF NOT EXISTS %s.threads (
-		id      bigint  PRIMARY KEY,
-		bump    bigint  NOT NULL,
-		bumpnum integer NOT NULL
+		id       bigint  PRIMARY KEY,
+		bump     bigint  NOT NULL,
+		bumpnum  integer NOT NULL,
+		sticky   boolean NOT NULL DEFAULT false,
+		locked   boolean NOT NULL DEFAULT false,
+		cyclical boolean NOT NULL DEFAULT false
 	)`
-	stmt, err = db.Prepare(fmt.Sprintf(create_q, dbi.Name))
+	stmt, err = db.Prepare(fmt.Sprintf(create_q, schema))
 	panicErr(err)
 	_, err = stmt.Exec()
 	panicErr(err)
 
+	makeBoardExtras(db, schema)
+
 	// create dir tree
 	err = os.MkdirAll(pathBoardDir(dbi.Name), os.ModePerm)
 	panicErr(err)
@@ -181,29 +333,103 @@ func makeNewBoard(db *sql.DB, dbi *newBoardInfo) {
 	panicErr(err)
 
 	// insert to board list
-	create_q = `INSERT INTO boards (name, description, info) VALUES ($1, $2, $3)`
+	create_q = `INSERT INTO boards (name, description, info, publiclog) VALUES ($1, $2, $3, $4)`
 	stmt, err = db.Prepare(create_q)
 	panicErr(err)
-	_, err = stmt.Exec(dbi.Name, dbi.Desc, dbi.Info)
+	_, err = stmt.Exec(dbi.Name, dbi.Desc, dbi.Info, dbi.PublicLog)
 	panicErr(err)
 
 	// we're done
 }
 
+// index and tables added after first version, shared by new and upgraded boards
+func makeBoardExtras(db *sql.DB, schema string) {
+	create_q := `CREATE INDEX IF NOT EXISTS posts_ip_addr_idx ON %s.posts USING gist (ip_addr inet_ops)`
+	stmt, err := db.Prepare(fmt.Sprintf(create_q, schema))
+	panicErr(err)
+	_, err = stmt.Exec()
+	panicErr(err)
+
+	// attachments beyond first one, see postFile
+	create_q = `CREATE TABLE IF NOT EXISTS %[1]s.post_files (
+		post     bigint  NOT NULL REFERENCES %[1]s.posts ON DELETE CASCADE,
+		idx      integer NOT NULL,
+		file     text    NOT NULL,
+		original text    NOT NULL,
+		thumb    text    NOT NULL,
+		PRIMARY KEY (post, idx)
+	)`
+	stmt, err = db.Prepare(fmt.Sprintf(create_q, schema))
+	panicErr(err)
+	_, err = stmt.Exec()
+	panicErr(err)
+}
+
+// brings tables of board created by older version up to date
+func upgradeBoard(db *sql.DB, board string) {
+	schema := boardSchema(board)
+
+	create_q := `ALTER TABLE %s.posts
+		ADD COLUMN IF NOT EXISTS banned  boolean NOT NULL DEFAULT false,
+		ADD COLUMN IF NOT EXISTS delpass text`
+	stmt, err := db.Prepare(fmt.Sprintf(create_q, schema))
+	panicErr(err)
+	_, err = stmt.Exec()
+	panicErr(err)
+
+	create_q = `ALTER TABLE %s.threads
+		ADD COLUMN IF NOT EXISTS sticky   boolean NOT NULL DEFAULT false,
+		ADD COLUMN IF NOT EXISTS locked   boolean NOT NULL DEFAULT false,
+		ADD COLUMN IF NOT EXISTS cyclical boolean NOT NULL DEFAULT false`
+	stmt, err = db.Prepare(fmt.Sprintf(create_q, schema))
+	panicErr(err)
+	_, err = stmt.Exec()
+	panicErr(err)
+
+	makeBoardExtras(db, schema)
+
+	forgetBoardStmts(board)
+}
+
+func upgradeBoards(db *sql.DB) {
+	rows, err := db.Query("SELECT name FROM boards")
+	panicErr(err)
+	defer rows.Close()
+	var boards []string
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		panicErr(err)
+		boards = append(boards, name)
+	}
+	for _, b := range boards {
+		if !validBoardName(b) {
+			fmt.Printf("warning: skipping board with invalid name %q\n", b)
+			continue
+		}
+		upgradeBoard(db, b)
+	}
+}
+
 func deleteBoard(db *sql.DB, name string) bool {
+	if !validBoardName(name) {
+		return false
+	}
+
 	var bname string
-	err := db.QueryRow("DELETE FROM boards WHERE name=$1 RETURNING name", name).Scan(&bname)
+	err := sqlStmt(db, "DELETE FROM boards WHERE name=$1 RETURNING name").QueryRow(name).Scan(&bname)
 	if err == sql.ErrNoRows {
 		// already deleted or invalid name, we have nothing to do there
 		return false
 	}
 	panicErr(err)
 
-	stmt, err := db.Prepare("DROP SCHEMA IF EXISTS $1")
+	stmt, err := db.Prepare("DROP SCHEMA IF EXISTS " + boardSchema(bname) + " CASCADE")
 	panicErr(err)
-	_, err = stmt.Exec(bname)
+	_, err = stmt.Exec()
 	panicErr(err)
 
+	forgetBoardStmts(bname)
 	os.RemoveAll(pathBoardDir(name))
 
 	return true
@@ -216,74 +442,157 @@ func postNewBoard(w http.ResponseWriter, r *http.Request) {
 
 	bname, ok := r.Form["name"]
 	if !ok {
-		http.Error(w, "400 bad request: no name field", 400)
+		reportError(w, r, newReqError(400, errMissingField, "no name field"))
 		return
 	}
 	nbi.Name = bname[0]
 	if !validBoardName(nbi.Name) {
-		http.Error(w, "400 bad request: invalid board name", 400)
+		reportError(w, r, newReqError(400, errBadRequest, "invalid board name"))
 		return
 	}
 
 	bdesc, ok := r.Form["desc"]
 	if !ok {
-		http.Error(w, "400 bad request: no desc field", 400)
+		reportError(w, r, newReqError(400, errMissingField, "no desc field"))
 		return
 	}
 	nbi.Desc = bdesc[0]
 
 	binfo, ok := r.Form["info"]
 	if !ok {
-		http.Error(w, "400 bad request: no info field", 400)
+		reportError(w, r, newReqError(400, errMissingField, "no info field"))
 		return
 	}
 	nbi.Info = binfo[0]
 
-	db := openSQL()
-	defer db.Close()
+	nbi.PublicLog = r.Form.Get("publiclog") != ""
+
+	db := sqlPool()
+
+	if sqlValidateBoard(db, nbi.Name) {
+		reportError(w, r, newReqError(409, errBoardExists, "board already exists"))
+		return
+	}
 
 	makeNewBoard(db, &nbi)
-	execTemplate(w, "boardcreated", &nbi)
+	reportResult(w, r, "boardcreated", &nbi)
 }
 
-func postDelBoard(w http.ResponseWriter, r *http.Request) {
-	var board string
+type delBoardResult struct {
+	Name string `json:"name"`
+}
 
-	bname, ok := r.Form["name"]
+func postDelBoard(w http.ResponseWriter, r *http.Request, a *adminAccount) {
+	r.ParseForm()
+
+	bname, ok := r.PostForm["name"]
 	if !ok {
-		http.Error(w, "400 bad request: no name field", 400)
+		reportError(w, r, newReqError(400, errMissingField, "no name field"))
 		return
 	}
-	board = bname[0]
+	board := bname[0]
 
-	db := openSQL()
-	defer db.Close()
+	// guard against deleting wrong board by mistake
+	if r.PostFormValue("confirm") != board {
+		reportError(w, r, newReqError(400, errBadRequest, "confirmation does not match board name"))
+		return
+	}
 
-	ok = deleteBoard(db, board)
-	if !ok {
-		http.Error(w, "500 internal server error: board deletion failed", 500)
+	db := sqlPool()
+
+	if !deleteBoard(db, board) {
+		reportError(w, r, newReqError(404, errBoardNotFound, "board not found"))
 		return
 	}
+	logAction(db, &logEntry{Actor: a.Name, Action: logDeleteBoard, Board: board, Reason: r.PostFormValue("reason")})
 
-	execTemplate(w, "boarddeleted", &board)
+	reportResult(w, r, "boarddeleted", &delBoardResult{Name: board})
 }
 
-// postinfo for writing
-type wPostInfo struct {
-	Name     string
-	Trip     string
-	Subject  string
-	Email    string
-	Message  string
+// uploaded file. first attachment of post is stored in file, original and thumb
+// columns of posts table, as it was before posts could have more of them; others
+// go to post_files with idx starting at 1. post without first file has no others.
+// readers of files therefore look at both, see loadExtraFiles and pruneExtraFiles
+type postFile struct {
 	File     string
 	Original string // original filename
 	Thumb    string
 }
 
+// postinfo for writing
+type wPostInfo struct {
+	Name    string
+	Trip    string
+	Subject string
+	Email   string
+	Message string
+	Files   []postFile // first one is stored in posts table, rest in post_files
+	IP      net.IP     // poster's address
+	DelPass string     // hash of deletion password
+	Sage    bool       // reply shouldn't bump thread
+	NoNoko  bool       // poster wants to return to board index instead of thread
+}
+
+// first attachment, or empty one if post has none
+func (p *wPostInfo) firstFile() (f postFile) {
+	if len(p.Files) != 0 {
+		f = p.Files[0]
+	}
+	return
+}
+
+// removes uploaded files of post which wasn't accepted
+func (p *wPostInfo) pruneFiles(board string) {
+	for i := range p.Files {
+		pruneFiles(board, p.Files[i].File, p.Files[i].Thumb)
+	}
+}
+
+// stores attachments after first one
+func insertExtraFiles(db *sql.DB, tx *sql.Tx, board string, post uint64, files []postFile) {
+	if len(files) < 2 {
+		return
+	}
+	ins := tx.Stmt(boardStmt(db, board, "INSERT INTO %s.post_files (post, idx, file, original, thumb) VALUES ($1, $2, $3, $4, $5)"))
+	for i := 1; i < len(files); i++ {
+		_, err := ins.Exec(post, i, files[i].File, files[i].Original, files[i].Thumb)
+		panicErr(err)
+	}
+}
+
+// attachments allowed per post on board, server default if board doesn't set it (NULL).
+// board may set 0 to disallow files
+func filesLimit(maxfiles sql.NullInt64) int {
+	if maxfiles.Valid {
+		return int(maxfiles.Int64)
+	}
+	return cfg.Limits.MaxFiles
+}
+
+// splits options out of email field. sage stays in email so that others see it,
+// noko and nonoko only matter to poster and are dropped
+func emailOptions(email string) (rest string, sage, noko, nonoko bool) {
+	var keep []string
+	for _, w := range strings.Fields(email) {
+		switch strings.ToLower(w) {
+		case "sage":
+			sage = true
+		case "noko":
+			noko = true
+			continue
+		case "nonoko":
+			nonoko = true
+			continue
+		}
+		keep = append(keep, w)
+	}
+	return strings.Join(keep, " "), sage, noko, nonoko
+}
+
 type postResult struct {
-	Board  string
-	Thread uint64
-	Post   uint64
+	Board  string `json:"board"`
+	Thread uint64 `json:"thread"`
+	Post   uint64 `json:"post"`
 }
 
 func (r *postResult) HasThread() bool {
@@ -294,57 +603,158 @@ func (r *postResult) IsThread() bool {
 	return r.Thread == r.Post
 }
 
-func acceptPost(w http.ResponseWriter, r *http.Request, p *wPostInfo, board string, isop bool) bool {
+// result of new post or thread. poster is sent to Redirect afterwards
+type newPostResult struct {
+	postResult
+	Redirect string `json:"redirect"`
+}
+
+// thread by default, or board index if poster asked for nonoko
+func newPostRedirect(board string, thread, post uint64, p *wPostInfo) *newPostResult {
+	pr := &newPostResult{postResult: postResult{Board: board, Thread: thread, Post: post}}
+	if p.NoNoko {
+		pr.Redirect = "/" + board + "/"
+	} else {
+		pr.Redirect = "/" + board + "/thread/" + strconv.FormatUint(thread, 10) + "#" + strconv.FormatUint(post, 10)
+	}
+	return pr
+}
+
+// sends poster away after successful post, so that reloading page doesn't post again.
+// template is sent as body for clients which don't follow redirects
+func reportPosted(w http.ResponseWriter, r *http.Request, tmpl string, pr *newPostResult) {
+	if wantJSON(r) {
+		execJSON(w, pr)
+		return
+	}
+	w.Header().Set("Location", pr.Redirect)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusSeeOther)
+	execTemplate(w, tmpl, pr)
+}
+
+// rejected post, with form filled with what poster entered so they can fix it
+type postFormError struct {
+	Board   string
+	Thread  uint64 // 0 for new thread
+	Error   *reqError
+	Name    string
+	Subject string
+	Email   string
+	Message string
+	Captcha string // new challenge, as old one was used up
+}
+
+func reportPostError(w http.ResponseWriter, r *http.Request, board string, thread uint64, captcha bool, e *reqError) {
+	if wantJSON(r) {
+		reportError(w, r, e)
+		return
+	}
+	fe := postFormError{
+		Board:   board,
+		Thread:  thread,
+		Error:   e,
+		Name:    r.FormValue("name"),
+		Subject: r.FormValue("subject"),
+		Email:   r.FormValue("email"),
+		Message: r.FormValue("message"),
+	}
+	if captcha {
+		fe.Captcha = newCaptcha()
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(e.Status)
+	execTemplate(w, "posterror", &fe)
+}
+
+// saves uploaded file and makes its thumb
+func acceptFile(h *multipart.FileHeader, board, mt string, isop bool) (pf postFile, e *reqError) {
+	f, err := h.Open()
+	if err != nil {
+		return pf, newReqError(500, errInternal, err.Error())
+	}
+	defer f.Close()
+
+	ext := filepath.Ext(h.Filename)
+	fname := strconv.FormatInt(uniqueTimestamp(), 10) + ext
+	fullname := pathSrcFile(board, fname)
+	tmpname := pathSrcFile(board, ".tmp."+fname)
+	nf, err := os.OpenFile(tmpname, os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return pf, newReqError(500, errInternal, err.Error())
+	}
+	io.Copy(nf, f)
+	nf.Close()
+	os.Rename(tmpname, fullname) // atomic :^)
+
+	pf.File = fname
+	pf.Original = h.Filename
+
+	tname, err := makeThumb(fullname, fname, board, ext, mt, isop)
+	if err != nil {
+		fmt.Printf("error generating thumb for %s: %s\n", fname, err)
+	}
+	pf.Thumb = tname
+
+	return pf, nil
+}
+
+// captcha tells whether board requires solved captcha, maxfiles how many attachments it allows
+func acceptPost(r *http.Request, p *wPostInfo, board string, isop, captcha bool, maxfiles int) *reqError {
 	var err error
 
 	err = r.ParseMultipartForm(1 << 20)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("400 bad request: ParseMultipartForm failed: %s", err), 400)
-		return false
+		return newReqError(400, errBadRequest, fmt.Sprintf("ParseMultipartForm failed: %s", err))
+	}
+
+	p.IP = clientIP(r)
+
+	// checked before saving any files, as failing it is common
+	if captcha && !solveCaptcha(r.FormValue("captcha_token"), r.FormValue("captcha")) {
+		return newReqError(403, errBadCaptcha, "wrong or expired captcha")
 	}
 
 	pname, ok := r.Form["name"]
 	if !ok {
-		http.Error(w, "400 bad request: has no name field", 400)
-		return false
+		return newReqError(400, errMissingField, "has no name field")
 	}
 	p.Name, p.Trip = MakeTrip(pname[0])
 
 	psubject, ok := r.Form["subject"]
 	if !ok {
-		http.Error(w, "400 bad request: has no subject field", 400)
-		return false
+		return newReqError(400, errMissingField, "has no subject field")
 	}
 	p.Subject = psubject[0]
 
 	pemail, ok := r.Form["email"]
 	if !ok {
-		http.Error(w, "400 bad request: has no email field", 400)
-		return false
+		return newReqError(400, errMissingField, "has no email field")
 	}
-	p.Email = pemail[0]
+	var noko, nonoko bool
+	p.Email, p.Sage, noko, nonoko = emailOptions(pemail[0])
+	p.NoNoko = nonoko && !noko // noko wins if both are given
 
 	pmessage, ok := r.Form["message"]
 	if !ok {
-		http.Error(w, "400 bad request: has no message field", 400)
-		return false
+		return newReqError(400, errMissingField, "has no message field")
 	}
 	p.Message = pmessage[0]
 
-	f, h, err := r.FormFile("file")
-	if err == nil {
-		defer f.Close()
-		size, err := f.Seek(0, os.SEEK_END)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("500 internal server error: %s", err), 500)
-			return false
-		}
-		_, err = f.Seek(0, os.SEEK_SET)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("500 internal server error: %s", err), 500)
-			return false
+	var fhs []*multipart.FileHeader
+	if r.MultipartForm != nil {
+		fhs = r.MultipartForm.File["file"]
+	}
+	if len(fhs) > maxfiles {
+		if maxfiles == 0 {
+			return newReqError(403, errFileNotAllowed, "files are not allowed on this board")
 		}
+		return newReqError(403, errTooManyFiles, fmt.Sprintf("too many files, at most %d allowed", maxfiles))
+	}
 
+	// check all files before saving any of them
+	mts := make([]string, len(fhs))
+	for i, h := range fhs {
 		ext := filepath.Ext(h.Filename)
 		mt := mime.TypeByExtension(ext)
 		if mt != "" {
@@ -352,155 +762,203 @@ func acceptPost(w http.ResponseWriter, r *http.Request, p *wPostInfo, board stri
 		}
 		maxSize, ok := allowedTypes[mt]
 		if !ok {
-			http.Error(w, "file type not allowed", 403) // 403 Forbidden
-			return false
-		}
-		if size > maxSize {
-			http.Error(w, "file too big", 403) // 403 Forbidden
-			return false
+			return newReqError(403, errFileNotAllowed, "file type not allowed")
 		}
-		fname := strconv.FormatInt(uniqueTimestamp(), 10) + ext
-		fullname := pathSrcFile(board, fname)
-		tmpname := pathSrcFile(board, ".tmp."+fname)
-		nf, err := os.OpenFile(tmpname, os.O_WRONLY|os.O_CREATE, 0666)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("500 internal server error: %s", err), 500)
-			return false
+		if h.Size > maxSize {
+			return newReqError(403, errFileTooBig, "file too big")
 		}
-		io.Copy(nf, f)
-		nf.Close()
-		os.Rename(tmpname, fullname) // atomic :^)
-
-		p.File = fname
-		p.Original = h.Filename
+		mts[i] = mt
+	}
 
-		tname, err := makeThumb(fullname, fname, board, ext, mt, isop)
-		if err != nil {
-			fmt.Printf("error generating thumb for %s: %s\n", fname, err)
+	for i, h := range fhs {
+		f, e := acceptFile(h, board, mts[i], isop)
+		if e != nil {
+			p.pruneFiles(board)
+			p.Files = nil
+			return e
 		}
-		p.Thumb = tname
+		p.Files = append(p.Files, f)
 	}
 
-	return true
+	return nil
 }
 
 func postNewThread(w http.ResponseWriter, r *http.Request, board string) {
 	var p wPostInfo
 
-	db := openSQL()
-	defer db.Close()
+	if !validBoardName(board) {
+		reportError(w, r, newReqError(404, errBoardNotFound, "board not found"))
+		return
+	}
+
+	db := sqlPool()
 
 	var bname string
-	var maxthreads sql.NullInt64
-	err := db.QueryRow("SELECT name, maxthreads FROM boards WHERE name=$1", board).Scan(&bname, &maxthreads)
+	var maxthreads, maxfiles sql.NullInt64
+	var captcha bool
+	err := sqlStmt(db, "SELECT name, maxthreads, captcha, maxfiles FROM boards WHERE name=$1").QueryRow(board).Scan(&bname, &maxthreads, &captcha, &maxfiles)
 	if err == sql.ErrNoRows {
-		http.NotFound(w, r)
+		reportError(w, r, newReqError(404, errBoardNotFound, "board not found"))
 		return
 	}
 	panicErr(err)
 
-	if !acceptPost(w, r, &p, board, true) {
+	if !checkBan(w, r, db) {
 		return
 	}
 
+	limits := boardFloodLimits(db, board)
+	if e := checkCooldown(db, board, clientIP(r), true, &limits); e != nil {
+		reportPostError(w, r, board, 0, captcha, e)
+		return
+	}
+
+	if e := acceptPost(r, &p, board, true, captcha, filesLimit(maxfiles)); e != nil {
+		reportPostError(w, r, board, 0, captcha, e)
+		return
+	}
+	if e := checkDuplicate(db, board, &p, &limits); e != nil {
+		p.pruneFiles(board)
+		reportPostError(w, r, board, 0, captcha, e)
+		return
+	}
+	p.DelPass = hashDelPass(posterPassword(w, r))
+
 	nowtime := utcUnixTime()
 
-	var lastInsertId uint64
-	err = db.QueryRow(fmt.Sprintf("INSERT INTO %s.posts (name, trip, subject, email, date, message, file, original, thumb) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id;", board),
-		p.Name, p.Trip, p.Subject, p.Email, nowtime, p.Message, p.File, p.Original, p.Thumb).Scan(&lastInsertId)
+	tx, err := db.Begin()
 	panicErr(err)
+	defer tx.Rollback()
 
-	stmt, err := db.Prepare(fmt.Sprintf("INSERT INTO %s.threads (id, bump, bumpnum) VALUES ($1, $2, $3)", board))
+	var lastInsertId uint64
+	f := p.firstFile()
+	err = tx.Stmt(boardStmt(db, board, "INSERT INTO %s.posts (name, trip, subject, email, date, message, file, original, thumb, ip_addr, delpass) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id")).
+		QueryRow(p.Name, p.Trip, p.Subject, p.Email, nowtime, p.Message, f.File, f.Original, f.Thumb, sqlIP(p.IP), p.DelPass).Scan(&lastInsertId)
 	panicErr(err)
-	_, err = stmt.Exec(lastInsertId, nowtime, 0)
+	insertExtraFiles(db, tx, board, lastInsertId, p.Files)
+
+	_, err = tx.Stmt(boardStmt(db, board, "INSERT INTO %s.threads (id, bump, bumpnum) VALUES ($1, $2, $3)")).Exec(lastInsertId, nowtime, 0)
 	panicErr(err)
+	panicErr(tx.Commit())
 
 	// prune excess threads if limit exists
 	if maxthreads.Valid && maxthreads.Int64 != 0 {
-		//var numrows uint64
-		//err = db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s.threads", board)).Scan(&numrows)
-		//panicErr(err)
-		delq := `
-			DELETE FROM %s.threads
-			WHERE id = any (array(
-				SELECT id FROM %s.threads
-				ORDER BY bump DESC
-				OFFSET $1))
-			RETURNING id`
-		rows, err := db.Query(fmt.Sprintf(delq, board, board), uint64(maxthreads.Int64))
-		panicErr(err)
-		for rows.Next() {
-			var tid uint64
-			err = rows.Scan(&tid)
-			panicErr(err)
-			prunePosts(db, board, tid)
-		}
+		pruneExcessThreads(db, board, int(maxthreads.Int64))
 	}
 
-	var pr = postResult{Board: board, Thread: lastInsertId, Post: lastInsertId}
-	execTemplate(w, "threadcreated", pr)
+	reportPosted(w, r, "threadcreated", newPostRedirect(board, lastInsertId, lastInsertId, &p))
 }
 
 func bumpThread(db *sql.DB, board string, thread uint64, t int64) {
 	q := `UPDATE %s.threads
 SET bump = $1, bumpnum = bumpnum + 1
 WHERE id = $2`
-	stmt, err := db.Prepare(fmt.Sprintf(q, board))
-	panicErr(err)
-	_, err = stmt.Exec(t, thread)
+	_, err := boardStmt(db, board, q).Exec(t, thread)
 	panicErr(err)
 }
 
 func postNewPost(w http.ResponseWriter, r *http.Request, board string, thread uint64) {
 	var p wPostInfo
 
-	db := openSQL()
-	defer db.Close()
+	if !validBoardName(board) {
+		reportError(w, r, newReqError(404, errBoardNotFound, "board not found"))
+		return
+	}
+
+	db := sqlPool()
 
-	var bumplimit sql.NullInt64
-	err := db.QueryRow("SELECT bumplimit FROM boards WHERE name=$1", board).Scan(&bumplimit)
+	var bumplimit, maxfiles sql.NullInt64
+	var captcha bool
+	err := sqlStmt(db, "SELECT bumplimit, captcha, maxfiles FROM boards WHERE name=$1").QueryRow(board).Scan(&bumplimit, &captcha, &maxfiles)
 	if err == sql.ErrNoRows {
-		http.NotFound(w, r)
+		reportError(w, r, newReqError(404, errBoardNotFound, "board not found"))
 		return
 	}
 	panicErr(err)
 
 	var bumpnum uint32
-	err = db.QueryRow(fmt.Sprintf("SELECT bumpnum FROM %s.threads WHERE id=$1", board), thread).Scan(&bumpnum)
+	var locked, cyclical bool
+	err = boardStmt(db, board, "SELECT bumpnum, locked, cyclical FROM %s.threads WHERE id=$1").QueryRow(thread).Scan(&bumpnum, &locked, &cyclical)
 	if err == sql.ErrNoRows {
-		http.NotFound(w, r)
+		reportError(w, r, newReqError(404, errThreadNotFound, "thread not found"))
 		return
 	}
 	panicErr(err)
+	if locked {
+		reportError(w, r, newReqError(403, errThreadLocked, "thread is locked"))
+		return
+	}
 
-	if !acceptPost(w, r, &p, board, false) {
+	if !checkBan(w, r, db) {
 		return
 	}
 
+	limits := boardFloodLimits(db, board)
+	if e := checkCooldown(db, board, clientIP(r), false, &limits); e != nil {
+		reportPostError(w, r, board, thread, captcha, e)
+		return
+	}
+
+	if e := acceptPost(r, &p, board, false, captcha, filesLimit(maxfiles)); e != nil {
+		reportPostError(w, r, board, thread, captcha, e)
+		return
+	}
+	if e := checkDuplicate(db, board, &p, &limits); e != nil {
+		p.pruneFiles(board)
+		reportPostError(w, r, board, thread, captcha, e)
+		return
+	}
+	p.DelPass = hashDelPass(posterPassword(w, r))
+
 	nowtime := utcUnixTime()
 
-	var lastInsertId uint64
-	err = db.QueryRow(fmt.Sprintf("INSERT INTO %s.posts (thread, name, trip, subject, email, date, message, file, original, thumb) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id;", board),
-		thread, p.Name, p.Trip, p.Subject, p.Email, nowtime, p.Message, p.File, p.Original, p.Thumb).Scan(&lastInsertId)
+	tx, err := db.Begin()
 	panicErr(err)
+	defer tx.Rollback()
 
-	// TODO: check for sage
-	if !bumplimit.Valid || bumpnum < uint32(bumplimit.Int64) {
+	// thread can't be moved or deleted until reply is in, and if it already was, reply isn't left orphaned
+	var tid uint64
+	err = tx.Stmt(boardStmt(db, board, "SELECT id FROM %s.threads WHERE id=$1 FOR SHARE")).QueryRow(thread).Scan(&tid)
+	if err == sql.ErrNoRows {
+		p.pruneFiles(board)
+		reportError(w, r, newReqError(404, errThreadNotFound, "thread not found"))
+		return
+	}
+	panicErr(err)
+
+	var lastInsertId uint64
+	f := p.firstFile()
+	err = tx.Stmt(boardStmt(db, board, "INSERT INTO %s.posts (thread, name, trip, subject, email, date, message, file, original, thumb, ip_addr, delpass) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id")).
+		QueryRow(thread, p.Name, p.Trip, p.Subject, p.Email, nowtime, p.Message, f.File, f.Original, f.Thumb, sqlIP(p.IP), p.DelPass).Scan(&lastInsertId)
+	panicErr(err)
+	insertExtraFiles(db, tx, board, lastInsertId, p.Files)
+	panicErr(tx.Commit())
+
+	if cyclical && bumplimit.Valid && bumplimit.Int64 > 0 {
+		// instead of reaching bump limit, make space by dropping oldest replies
+		pruneOldReplies(db, board, thread, int(bumplimit.Int64))
+		if !p.Sage {
+			bumpThread(db, board, thread, nowtime)
+		}
+	} else if !p.Sage && (!bumplimit.Valid || bumpnum < uint32(bumplimit.Int64)) {
 		bumpThread(db, board, thread, nowtime)
 	}
 
-	var pr = postResult{Board: board, Thread: thread, Post: lastInsertId}
-	execTemplate(w, "posted", pr)
+	reportPosted(w, r, "posted", newPostRedirect(board, thread, lastInsertId, &p))
 }
 
-func removePost(w http.ResponseWriter, r *http.Request, pr *postResult, board string, post uint64) bool {
-	db := openSQL()
-	defer db.Close()
+// deletes post, or whole thread if post is OP. action is logged with given actor and reason
+func removePost(w http.ResponseWriter, r *http.Request, pr *postResult, board string, post uint64, actor, reason string) bool {
+	if !validBoardName(board) {
+		reportError(w, r, newReqError(404, errBoardNotFound, "board not found"))
+		return false
+	}
+	db := sqlPool()
 
 	var bname string
-	err := db.QueryRow("SELECT name FROM boards WHERE name=$1", board).Scan(&bname)
+	err := sqlStmt(db, "SELECT name FROM boards WHERE name=$1").QueryRow(board).Scan(&bname)
 	if err == sql.ErrNoRows {
-		http.NotFound(w, r)
+		reportError(w, r, newReqError(404, errBoardNotFound, "board not found"))
 		return false
 	}
 	panicErr(err)
@@ -508,10 +966,12 @@ func removePost(w http.ResponseWriter, r *http.Request, pr *postResult, board st
 	pr.Board = board
 	pr.Post = post
 
+	pruneExtraFiles(db, board, "id=$1", post)
+
 	var thread sql.NullInt64
 	var fname sql.NullString
 	var tname sql.NullString
-	err = db.QueryRow(fmt.Sprintf("DELETE FROM %s.posts WHERE id=$1 RETURNING thread, file, thumb", board), post).Scan(&thread, &fname, &tname)
+	err = boardStmt(db, board, "DELETE FROM %s.posts WHERE id=$1 RETURNING thread, file, thumb").QueryRow(post).Scan(&thread, &fname, &tname)
 	if err == sql.ErrNoRows {
 		return true // already deleted
 	}
@@ -520,32 +980,135 @@ func removePost(w http.ResponseWriter, r *http.Request, pr *postResult, board st
 	pruneFiles(board, fname.String, tname.String)
 
 	// if it was OP, prune whole thread
+	action := logDeletePost
 	if !thread.Valid || thread.Int64 == 0 || uint64(thread.Int64) == post {
 		pr.Thread = post
 		pruneThreadReplies(db, board, post)
+		action = logDeleteThread
 	} else {
 		pr.Thread = uint64(thread.Int64)
 	}
 
+	logAction(db, &logEntry{Actor: actor, Action: action, Board: board, Thread: pr.Thread, Post: post, Reason: reason})
+	if action == logDeleteThread {
+		pruneReports(db, board) // replies went with it
+	} else {
+		clearReports(db, board, post)
+	}
+
 	return true
 }
 
+// deletes files of post, keeping its text
+func removePostFile(w http.ResponseWriter, r *http.Request, pr *postResult, board string, post uint64, actor, reason string) bool {
+	if !validBoardName(board) {
+		reportError(w, r, newReqError(404, errBoardNotFound, "board not found"))
+		return false
+	}
+	db := sqlPool()
+
+	pr.Board = board
+	pr.Post = post
+
+	var thread sql.NullInt64
+	var fname, tname sql.NullString
+	err := boardStmt(db, board, "SELECT thread, file, thumb FROM %s.posts WHERE id=$1").QueryRow(post).Scan(&thread, &fname, &tname)
+	if err == sql.ErrNoRows {
+		reportError(w, r, newReqError(404, errPostNotFound, "post not found"))
+		return false
+	}
+	panicErr(err)
+
+	if thread.Valid && thread.Int64 != 0 {
+		pr.Thread = uint64(thread.Int64)
+	} else {
+		pr.Thread = post
+	}
+
+	if fname.String == "" || fname.String == deletedFile {
+		return true // nothing to delete
+	}
+
+	// post keeps pointing at static thumb so that it's visible that file was there
+	_, err = boardStmt(db, board, "UPDATE %s.posts SET file=$2, original='', thumb=$2 WHERE id=$1").Exec(post, deletedFile)
+	panicErr(err)
+
+	pruneFiles(board, fname.String, tname.String)
+	pruneExtraFiles(db, board, "id=$1", post)
+
+	logAction(db, &logEntry{Actor: actor, Action: logDeleteFile, Board: board, Thread: pr.Thread, Post: post, Reason: reason})
+
+	return true
+}
+
+type deleteResult struct {
+	postResult
+	FileOnly bool `json:"file_only"` // only file of post was deleted
+}
+
 func postDelete(w http.ResponseWriter, r *http.Request, board string) {
 	r.ParseForm()
 	post, ok := r.PostForm["id"]
 	if !ok {
-		http.Error(w, "400 bad request: no post id specified", 400)
+		reportError(w, r, newReqError(400, errMissingField, "no post id specified"))
 		return
 	}
 	n, err := strconv.ParseUint(post[0], 10, 64)
 	if err != nil {
-		http.Error(w, "400 bad request: bad post id", 400)
+		reportError(w, r, newReqError(400, errBadPostId, "bad post id"))
+		return
+	}
+	var pr deleteResult
+	pr.FileOnly = r.PostFormValue("fileonly") != ""
+	if pr.FileOnly {
+		if !removePostFile(w, r, &pr.postResult, board, n, logActor(r), r.PostFormValue("reason")) {
+			return
+		}
+	} else {
+		if !removePost(w, r, &pr.postResult, board, n, logActor(r), r.PostFormValue("reason")) {
+			return
+		}
+	}
+
+	reportResult(w, r, "deleted", &pr)
+}
+
+// thread flags moderators can toggle, with log actions for setting and clearing them
+var threadFlags = map[string]struct{ set, unset string }{
+	"sticky":   {logSticky, logUnsticky},
+	"locked":   {logLock, logUnlock},
+	"cyclical": {logCyclical, logUncyclical},
+}
+
+func postThreadFlag(w http.ResponseWriter, r *http.Request, board string, thread uint64, flag string) {
+	actions, ok := threadFlags[flag]
+	if !ok {
+		http.NotFound(w, r)
 		return
 	}
-	var pr postResult
-	if !removePost(w, r, &pr, board, n) {
+	r.ParseForm()
+	set := r.PostFormValue("set") != ""
+
+	db := sqlPool()
+
+	if !sqlValidateBoard(db, board) {
+		reportError(w, r, newReqError(404, errBoardNotFound, "board not found"))
 		return
 	}
 
-	execTemplate(w, "deleted", &pr)
+	// flag comes only from threadFlags, so it's safe to put it in query
+	res, err := boardStmt(db, board, "UPDATE %s.threads SET "+flag+"=$2 WHERE id=$1").Exec(thread, set)
+	panicErr(err)
+	if n, _ := res.RowsAffected(); n == 0 {
+		reportError(w, r, newReqError(404, errThreadNotFound, "thread not found"))
+		return
+	}
+
+	action := actions.unset
+	if set {
+		action = actions.set
+	}
+	logAction(db, &logEntry{Actor: logActor(r), Action: action, Board: board, Thread: thread, Post: thread, Reason: r.PostFormValue("reason")})
+
+	reportDone(w, r, fmt.Sprintf("/%s/mod/%d", board, thread))
 }
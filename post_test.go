@@ -0,0 +1,46 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestEmailOptions(t *testing.T) {
+	var tests = [...]struct {
+		src, rest          string
+		sage, noko, nonoko bool
+	}{
+		{src: "", rest: ""},
+		{src: "foo@example.com", rest: "foo@example.com"},
+		{src: "sage", rest: "sage", sage: true},
+		{src: "SAGE", rest: "SAGE", sage: true},
+		{src: "noko", rest: "", noko: true},
+		{src: "nonoko", rest: "", nonoko: true},
+		{src: "sage nonoko", rest: "sage", sage: true, nonoko: true},
+		{src: "sagee", rest: "sagee"},
+	}
+	for i := range tests {
+		e := &tests[i]
+		rest, sage, noko, nonoko := emailOptions(e.src)
+		if rest != e.rest || sage != e.sage || noko != e.noko || nonoko != e.nonoko {
+			t.Errorf("%q: expected: %q %t %t %t; got: %q %t %t %t\n", e.src, e.rest, e.sage, e.noko, e.nonoko, rest, sage, noko, nonoko)
+		}
+	}
+}
+
+func TestFilesLimit(t *testing.T) {
+	type limitset struct {
+		maxfiles sql.NullInt64
+		limit    int
+	}
+	var tests = [...]limitset{
+		{maxfiles: sql.NullInt64{}, limit: cfg.Limits.MaxFiles},
+		{maxfiles: sql.NullInt64{Int64: 0, Valid: true}, limit: 0},
+		{maxfiles: sql.NullInt64{Int64: 3, Valid: true}, limit: 3},
+	}
+	for i := range tests {
+		if limit := filesLimit(tests[i].maxfiles); limit != tests[i].limit {
+			t.Errorf("filesLimit(%v): expected: %d; got: %d\n", tests[i].maxfiles, tests[i].limit, limit)
+		}
+	}
+}
@@ -2,20 +2,54 @@ package main
 
 import (
 	"database/sql"
-	"fmt"
 	_ "github.com/lib/pq"
+	"sync"
+	"time"
 )
 
 func openSQL() *sql.DB {
-	dbinfo := fmt.Sprintf("user=%s password=%s dbname=%s sslmode=disable", "postgres", "postgres", "chin")
-	db, err := sql.Open("postgres", dbinfo)
+	db, err := sql.Open("postgres", configDSN())
 	panicErr(err)
+	db.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+	db.SetConnMaxLifetime(time.Duration(cfg.Database.ConnMaxLifetime) * time.Second)
 	return db
 }
 
+// long-lived pool shared by all requests, opened on first use
+var sharedDB struct {
+	once sync.Once
+	db   *sql.DB
+}
+
+func sqlPool() *sql.DB {
+	sharedDB.once.Do(func() {
+		sharedDB.db = openSQL()
+	})
+	return sharedDB.db
+}
+
+// columns of posts table loaded for rendering, in order scanPost expects them
+const postColumns = "id, name, trip, subject, email, date, message, file, original, thumb, banned"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPost(s rowScanner, p *fullPostInfo) error {
+	return s.Scan(&p.Id, &p.Name, &p.Trip, &p.Subject, &p.Email, &p.Date, &p.Message, &p.File, &p.Original, &p.Thumb, &p.Banned)
+}
+
+const threadColumns = "id, bump, sticky, locked, cyclical"
+
+func scanThread(s rowScanner, t *threadInfo) error {
+	return s.Scan(&t.Id, &t.Bump, &t.Sticky, &t.Locked, &t.Cyclical)
+}
+
 func inputBoards(db *sql.DB, f *fullFrontData) {
-	rows, err := db.Query("SELECT name, description, info FROM boards")
+	rows, err := sqlStmt(db, "SELECT name, description, info FROM boards").Query()
 	panicErr(err)
+	defer rows.Close()
 
 	for rows.Next() {
 		var b boardInfo
@@ -24,21 +58,49 @@ func inputBoards(db *sql.DB, f *fullFrontData) {
 	}
 }
 
-func inputThreads(db *sql.DB, b *fullBoardInfo, board string) bool {
-	err := db.QueryRow("SELECT name, description, info FROM boards WHERE name=$1", board).Scan(&b.Name, &b.Desc, &b.Info)
+// loads threads of board index page (counting from 1, 0 means all threads).
+// for each thread only last previews replies are loaded, or all if previews is negative
+func inputThreads(db *sql.DB, b *fullBoardInfo, board string, page, perpage, previews int) bool {
+	if !validBoardName(board) {
+		return false
+	}
+	err := sqlStmt(db, "SELECT name, description, info FROM boards WHERE name=$1").QueryRow(board).Scan(&b.Name, &b.Desc, &b.Info)
 	if err == sql.ErrNoRows {
 		return false
 	}
 	panicErr(err)
 
-	// TODO: ordering & limiting
-	rows, err := db.Query(fmt.Sprintf("SELECT id FROM %s.threads ORDER BY bump DESC", board))
+	var numthreads int
+	err = boardStmt(db, board, "SELECT COUNT(*) FROM %s.threads").QueryRow().Scan(&numthreads)
 	panicErr(err)
+
+	b.Page = page
+	if page > 0 && perpage > 0 {
+		b.NumPages = (numthreads + perpage - 1) / perpage
+		if b.NumPages == 0 {
+			b.NumPages = 1
+		}
+		if page > b.NumPages {
+			return false
+		}
+	} else {
+		b.NumPages = 1
+	}
+
+	var rows *sql.Rows
+	if page > 0 && perpage > 0 {
+		rows, err = boardStmt(db, board, "SELECT "+threadColumns+" FROM %s.threads ORDER BY sticky DESC, bump DESC LIMIT $1 OFFSET $2").Query(perpage, (page-1)*perpage)
+	} else {
+		rows, err = boardStmt(db, board, "SELECT "+threadColumns+" FROM %s.threads ORDER BY sticky DESC, bump DESC").Query()
+	}
+	panicErr(err)
+	defer rows.Close()
 	for rows.Next() {
 		var t fullThreadInfo
 		t.parent = &b.boardInfo
 		t.postMap = make(map[uint64]int)
-		rows.Scan(&t.Id)
+		err = scanThread(rows, &t.threadInfo)
+		panicErr(err)
 		b.Threads = append(b.Threads, t)
 	}
 
@@ -48,8 +110,8 @@ func inputThreads(db *sql.DB, b *fullBoardInfo, board string) bool {
 			op.parent = &b.Threads[i].threadInfo
 			op.fparent = &b.Threads[i]
 			// expliclty fetch OP
-			err = db.QueryRow(fmt.Sprintf("SELECT id, name, trip, subject, email, date, message, file, original, thumb FROM %s.posts WHERE id=$1", board), b.Threads[i].Id).
-				Scan(&op.Id, &op.Name, &op.Trip, &op.Subject, &op.Email, &op.Date, &op.Message, &op.File, &op.Original, &op.Thumb)
+			row := boardStmt(db, board, "SELECT "+postColumns+" FROM %s.posts WHERE id=$1").QueryRow(b.Threads[i].Id)
+			err = scanPost(row, &op)
 			if err == sql.ErrNoRows {
 				// thread without OP, it broke. TODO: remove from list
 			} else {
@@ -59,14 +121,30 @@ func inputThreads(db *sql.DB, b *fullBoardInfo, board string) bool {
 			b.Threads[i].postMap[op.Id] = 0
 		}
 
-		// TODO sorting and limiting (we need to show only few posts in board view)
-		rows, err = db.Query(fmt.Sprintf("SELECT id, name, trip, subject, email, date, message, file, original, thumb FROM %s.posts WHERE thread=$1", board), b.Threads[i].Id)
+		b.Threads[i].NumReplies, b.Threads[i].NumImages = countReplies(db, board, b.Threads[i].Id)
+
+		if previews == 0 {
+			continue
+		}
+		if previews > 0 {
+			q := `SELECT * FROM (
+				SELECT ` + postColumns + `
+				FROM %s.posts
+				WHERE thread=$1
+				ORDER BY id DESC
+				LIMIT $2) AS last
+			ORDER BY id ASC`
+			rows, err = boardStmt(db, board, q).Query(b.Threads[i].Id, previews)
+		} else {
+			rows, err = boardStmt(db, board, "SELECT "+postColumns+" FROM %s.posts WHERE thread=$1 ORDER BY id ASC").Query(b.Threads[i].Id)
+		}
 		panicErr(err)
+		defer rows.Close()
 		for rows.Next() {
 			var p fullPostInfo
 			p.parent = &b.Threads[i].threadInfo
 			p.fparent = &b.Threads[i]
-			err = rows.Scan(&p.Id, &p.Name, &p.Trip, &p.Subject, &p.Email, &p.Date, &p.Message, &p.File, &p.Original, &p.Thumb)
+			err = scanPost(rows, &p)
 			panicErr(err)
 			if p.Id == b.Threads[i].Id {
 				continue // OP already included -- shouldn't normally happen
@@ -79,15 +157,28 @@ func inputThreads(db *sql.DB, b *fullBoardInfo, board string) bool {
 	return true
 }
 
+// reply and image counts of thread. every attachment of reply counts as image, same as in catalog
+func countReplies(db *sql.DB, board string, thread uint64) (replies, images int) {
+	q := `SELECT COUNT(*), COUNT(NULLIF(NULLIF(file, ''), '/deleted')) +
+		(SELECT COUNT(*) FROM %[1]s.post_files AS f JOIN %[1]s.posts AS fr ON fr.id = f.post WHERE fr.thread=$1 AND fr.id<>$1)
+	FROM %[1]s.posts WHERE thread=$1 AND id<>$1`
+	err := boardStmt(db, board, q).QueryRow(thread).Scan(&replies, &images)
+	panicErr(err)
+	return
+}
+
 func inputPosts(db *sql.DB, t *fullThreadInfo, board string, thread uint64) bool {
+	if !validBoardName(board) {
+		return false
+	}
 	t.parent = &boardInfo{}
-	err := db.QueryRow("SELECT name, description, info FROM boards WHERE name=$1", board).Scan(&t.parent.Name, &t.parent.Desc, &t.parent.Info)
+	err := sqlStmt(db, "SELECT name, description, info FROM boards WHERE name=$1").QueryRow(board).Scan(&t.parent.Name, &t.parent.Desc, &t.parent.Info)
 	if err == sql.ErrNoRows {
 		return false
 	}
 	panicErr(err)
 
-	err = db.QueryRow(fmt.Sprintf("SELECT id FROM %s.threads WHERE id=$1", board), thread).Scan(&t.Id)
+	err = scanThread(boardStmt(db, board, "SELECT "+threadColumns+" FROM %s.threads WHERE id=$1").QueryRow(thread), &t.threadInfo)
 	if err == sql.ErrNoRows {
 		return false
 	}
@@ -95,8 +186,8 @@ func inputPosts(db *sql.DB, t *fullThreadInfo, board string, thread uint64) bool
 
 	t.Op.parent = &t.threadInfo
 	t.Op.fparent = t
-	err = db.QueryRow(fmt.Sprintf("SELECT id, name, trip, subject, email, date, message, file, original, thumb FROM %s.posts WHERE id=$1", board), thread).
-		Scan(&t.Op.Id, &t.Op.Name, &t.Op.Trip, &t.Op.Subject, &t.Op.Email, &t.Op.Date, &t.Op.Message, &t.Op.File, &t.Op.Original, &t.Op.Thumb)
+	row := boardStmt(db, board, "SELECT "+postColumns+" FROM %s.posts WHERE id=$1").QueryRow(thread)
+	err = scanPost(row, &t.Op)
 	if err == sql.ErrNoRows {
 		return false
 	}
@@ -104,13 +195,14 @@ func inputPosts(db *sql.DB, t *fullThreadInfo, board string, thread uint64) bool
 
 	t.postMap[t.Op.Id] = 0
 
-	rows, err := db.Query(fmt.Sprintf("SELECT id, name, trip, subject, email, date, message, file, original, thumb FROM %s.posts WHERE thread=$1", board), thread)
+	rows, err := boardStmt(db, board, "SELECT "+postColumns+" FROM %s.posts WHERE thread=$1 ORDER BY id ASC").Query(thread)
 	panicErr(err)
+	defer rows.Close()
 	for rows.Next() {
 		var p fullPostInfo
 		p.parent = &t.threadInfo
 		p.fparent = t
-		err = rows.Scan(&p.Id, &p.Name, &p.Trip, &p.Subject, &p.Email, &p.Date, &p.Message, &p.File, &p.Original, &p.Thumb)
+		err = scanPost(rows, &p)
 		panicErr(err)
 		if p.Id == thread {
 			continue // OP already included
@@ -118,6 +210,62 @@ func inputPosts(db *sql.DB, t *fullThreadInfo, board string, thread uint64) bool
 		t.Replies = append(t.Replies, p)
 		t.postMap[p.Id] = len(t.Replies)
 	}
+	t.NumReplies, t.NumImages = countReplies(db, board, thread)
+
+	return true
+}
+
+// catalog sort orders, mapped to ORDER BY clauses
+var catalogOrders = map[string]string{
+	"bump":    "t.bump DESC",
+	"created": "t.id DESC",
+	"replies": "COUNT(r.id) DESC, t.bump DESC",
+}
+
+// loads OPs of all threads together with reply and image counts
+func inputCatalog(db *sql.DB, b *fullBoardInfo, board, order string) bool {
+	if !validBoardName(board) {
+		return false
+	}
+	err := sqlStmt(db, "SELECT name, description, info FROM boards WHERE name=$1").QueryRow(board).Scan(&b.Name, &b.Desc, &b.Info)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	panicErr(err)
+
+	orderq, ok := catalogOrders[order]
+	if !ok {
+		orderq = catalogOrders["bump"]
+	}
+
+	// orderq comes only from catalogOrders, so there is limited set of distinct statements
+	q := `SELECT t.id, t.bump, t.sticky, t.locked, t.cyclical, p.id, p.name, p.trip, p.subject, p.email, p.date, p.message, p.file, p.original, p.thumb, p.banned,
+		COUNT(r.id), COUNT(NULLIF(NULLIF(r.file, ''), '/deleted')) +
+			(SELECT COUNT(*) FROM %[1]s.post_files AS f JOIN %[1]s.posts AS fr ON fr.id = f.post WHERE fr.thread = t.id AND fr.id <> t.id)
+	FROM %[1]s.threads AS t
+	JOIN %[1]s.posts AS p ON p.id = t.id
+	LEFT JOIN %[1]s.posts AS r ON r.thread = t.id AND r.id <> t.id
+	GROUP BY t.id, p.id
+	ORDER BY t.sticky DESC, ` + orderq
+	rows, err := boardStmt(db, board, q).Query()
+	panicErr(err)
+	defer rows.Close()
+	for rows.Next() {
+		var t fullThreadInfo
+		t.parent = &b.boardInfo
+		t.postMap = make(map[uint64]int)
+		op := &t.Op
+		err = rows.Scan(&t.Id, &t.Bump, &t.Sticky, &t.Locked, &t.Cyclical, &op.Id, &op.Name, &op.Trip, &op.Subject, &op.Email, &op.Date, &op.Message, &op.File, &op.Original, &op.Thumb, &op.Banned,
+			&t.NumReplies, &t.NumImages)
+		panicErr(err)
+		b.Threads = append(b.Threads, t)
+	}
+	// parent pointers can only be set once slice stops growing
+	for i := range b.Threads {
+		b.Threads[i].Op.parent = &b.Threads[i].threadInfo
+		b.Threads[i].Op.fparent = &b.Threads[i]
+		b.Threads[i].postMap[b.Threads[i].Op.Id] = 0
+	}
 
 	return true
 }
@@ -2,7 +2,6 @@ package main
 
 import (
 	"database/sql"
-	"fmt"
 	"os"
 )
 
@@ -15,9 +14,26 @@ func pruneFiles(board, fname, tname string) {
 	}
 }
 
+// removes extra attachments of posts matching cond, which must be done before removing posts themselves.
+// cond is condition on %[1]s.posts, there is only fixed set of them
+func pruneExtraFiles(db *sql.DB, board, cond string, args ...interface{}) {
+	q := "DELETE FROM %[1]s.post_files WHERE post IN (SELECT id FROM %[1]s.posts WHERE " + cond + ") RETURNING file, thumb"
+	rows, err := boardStmt(db, board, q).Query(args...)
+	panicErr(err)
+	defer rows.Close()
+	for rows.Next() {
+		var fname, tname string
+		err = rows.Scan(&fname, &tname)
+		panicErr(err)
+		pruneFiles(board, fname, tname)
+	}
+}
+
 func pruneReplies(db *sql.DB, board string, thread uint64) {
-	rows, err := db.Query(fmt.Sprintf("DELETE FROM %s.posts WHERE thread=$1 RETURNING file, thumb", board), thread)
+	pruneExtraFiles(db, board, "thread=$1", thread)
+	rows, err := boardStmt(db, board, "DELETE FROM %s.posts WHERE thread=$1 RETURNING file, thumb").Query(thread)
 	panicErr(err)
+	defer rows.Close()
 	for rows.Next() {
 		var fname, tname sql.NullString
 		err = rows.Scan(&fname, &tname)
@@ -27,8 +43,9 @@ func pruneReplies(db *sql.DB, board string, thread uint64) {
 }
 
 func pruneOp(db *sql.DB, board string, thread uint64) {
+	pruneExtraFiles(db, board, "id=$1", thread)
 	var fname, tname sql.NullString
-	err := db.QueryRow(fmt.Sprintf("DELETE FROM %s.posts WHERE id=$1 RETURNING file, thumb", board), thread).Scan(&fname, &tname)
+	err := boardStmt(db, board, "DELETE FROM %s.posts WHERE id=$1 RETURNING file, thumb").QueryRow(thread).Scan(&fname, &tname)
 	if err == sql.ErrNoRows {
 		return
 	}
@@ -37,9 +54,7 @@ func pruneOp(db *sql.DB, board string, thread uint64) {
 }
 
 func pruneThread(db *sql.DB, board string, thread uint64) {
-	stmt, err := db.Prepare(fmt.Sprintf("DELETE FROM %s.threads WHERE id=$1", board))
-	panicErr(err)
-	_, err = stmt.Exec(thread)
+	_, err := boardStmt(db, board, "DELETE FROM %s.threads WHERE id=$1").Exec(thread)
 	panicErr(err)
 }
 
@@ -48,6 +63,64 @@ func prunePosts(db *sql.DB, board string, thread uint64) {
 	pruneOp(db, board, thread)
 }
 
+// leaves only newest keep replies of thread
+func pruneOldReplies(db *sql.DB, board string, thread uint64, keep int) {
+	cond := `thread=$1 AND id<>$1 AND id NOT IN (
+		SELECT id FROM %[1]s.posts
+		WHERE thread=$1 AND id<>$1
+		ORDER BY id DESC
+		LIMIT $2)`
+	pruneExtraFiles(db, board, cond, thread, keep)
+	q := "DELETE FROM %[1]s.posts WHERE " + cond + " RETURNING id, file, thumb"
+	rows, err := boardStmt(db, board, q).Query(thread, keep)
+	panicErr(err)
+	defer rows.Close()
+	var pids []uint64
+	for rows.Next() {
+		var pid uint64
+		var fname, tname sql.NullString
+		err = rows.Scan(&pid, &fname, &tname)
+		panicErr(err)
+		pruneFiles(board, fname.String, tname.String)
+		pids = append(pids, pid)
+	}
+	for _, pid := range pids {
+		logAction(db, &logEntry{Action: logPrunePost, Board: board, Thread: thread, Post: pid, Reason: "cyclical thread"})
+	}
+	if len(pids) != 0 {
+		pruneReports(db, board)
+	}
+}
+
+// removes threads beyond limit, least recently bumped first. sticky threads don't count
+func pruneExcessThreads(db *sql.DB, board string, maxthreads int) {
+	delq := `
+		DELETE FROM %[1]s.threads
+		WHERE id = any (array(
+			SELECT id FROM %[1]s.threads
+			WHERE NOT sticky
+			ORDER BY bump DESC
+			OFFSET $1))
+		RETURNING id`
+	rows, err := boardStmt(db, board, delq).Query(maxthreads)
+	panicErr(err)
+	defer rows.Close()
+	var tids []uint64
+	for rows.Next() {
+		var tid uint64
+		err = rows.Scan(&tid)
+		panicErr(err)
+		tids = append(tids, tid)
+	}
+	for _, tid := range tids {
+		prunePosts(db, board, tid)
+		logAction(db, &logEntry{Action: logPruneThread, Board: board, Thread: tid, Post: tid, Reason: "thread limit reached"})
+	}
+	if len(tids) != 0 {
+		pruneReports(db, board)
+	}
+}
+
 func pruneThreadReplies(db *sql.DB, board string, thread uint64) {
 	pruneThread(db, board, thread)
 	pruneReplies(db, board, thread)
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"sync"
+)
+
+// quoted schema identifier of board. this is the only place where board names
+// become part of SQL, callers must have rejected invalid names before reaching here
+func boardSchema(board string) string {
+	if !validBoardName(board) {
+		panic(fmt.Errorf("invalid board name %q used as identifier", board))
+	}
+	return pq.QuoteIdentifier(board)
+}
+
+// prepared statements cache.
+// statements are keyed by board and query template, board name is substituted
+// in place of %s (or %[1]s) in template. board "" is for queries outside board schemas
+var stmtCache = struct {
+	sync.Mutex
+	boards map[string]map[string]*sql.Stmt
+}{boards: make(map[string]map[string]*sql.Stmt)}
+
+func boardStmt(db *sql.DB, board, q string) *sql.Stmt {
+	stmtCache.Lock()
+	stmt, ok := stmtCache.boards[board][q]
+	stmtCache.Unlock()
+	if ok {
+		return stmt
+	}
+
+	// preparing takes round trip to server, other queries shouldn't wait for it
+	var err error
+	if board != "" {
+		stmt, err = db.Prepare(fmt.Sprintf(q, boardSchema(board)))
+	} else {
+		stmt, err = db.Prepare(q)
+	}
+	panicErr(err)
+
+	stmtCache.Lock()
+	defer stmtCache.Unlock()
+	bm, ok := stmtCache.boards[board]
+	if !ok {
+		bm = make(map[string]*sql.Stmt)
+		stmtCache.boards[board] = bm
+	}
+	if prev, ok := bm[q]; ok {
+		// someone else prepared it meanwhile
+		stmt.Close()
+		return prev
+	}
+	bm[q] = stmt
+	return stmt
+}
+
+func sqlStmt(db *sql.DB, q string) *sql.Stmt {
+	return boardStmt(db, "", q)
+}
+
+// drops cached statements of board, should be done when board schema changes.
+// they aren't closed as other requests may still be running them. that leaves them
+// prepared on connections which used them, which is fine as schemas rarely change
+func forgetBoardStmts(board string) {
+	stmtCache.Lock()
+	defer stmtCache.Unlock()
+
+	delete(stmtCache.boards, board)
+}
@@ -11,8 +11,7 @@ type fullFrontData struct {
 }
 
 func renderFront(w http.ResponseWriter, r *http.Request) {
-	db := openSQL()
-	defer db.Close()
+	db := sqlPool()
 
 	var f fullFrontData
 	inputBoards(db, &f)
@@ -20,37 +19,65 @@ func renderFront(w http.ResponseWriter, r *http.Request) {
 	execTemplate(w, "front", &f)
 }
 
-func renderBoard(w http.ResponseWriter, r *http.Request, board string, mod bool) {
-	db := openSQL()
-	defer db.Close()
+func renderBoard(w http.ResponseWriter, r *http.Request, board string, page int, mod bool) {
+	db := sqlPool()
 
 	var b fullBoardInfo
-	if !inputThreads(db, &b, board) {
+	if !inputThreads(db, &b, board, page, cfg.Limits.ThreadsPerPage, cfg.Limits.PreviewReplies) {
 		http.NotFound(w, r)
 		return
 	}
 	b.setMod(mod)
 	b.setBoardView(true)
-	for i := range b.Threads {
-		processThread(&b.Threads[i], db)
+	if boardCaptcha(db, board) {
+		b.Captcha = newCaptcha()
 	}
+	processThreads(b.Threads, db)
 
 	execTemplate(w, "board", &b)
 }
 
 func renderThread(w http.ResponseWriter, r *http.Request, board string, thread uint64, mod bool) {
-	db := openSQL()
-	defer db.Close()
+	db := sqlPool()
 
 	var t fullThreadInfo
 	t.postMap = make(map[uint64]int)
 	if !inputPosts(db, &t, board, thread) {
-		http.NotFound(w, r)
+		restype := "thread"
+		if mod {
+			restype = "mod"
+		}
+		if !redirectMoved(w, r, board, thread, restype, "") {
+			http.NotFound(w, r)
+		}
 		return
 	}
 	t.setMod(mod)
 	t.setBoardView(false)
+	if boardCaptcha(db, board) {
+		t.setCaptcha(newCaptcha())
+	}
 	processThread(&t, db)
 
 	execTemplate(w, "thread", &t)
 }
+
+func renderCatalog(w http.ResponseWriter, r *http.Request, board string) {
+	db := sqlPool()
+
+	var c fullCatalogInfo
+	c.Sort = r.FormValue("sort")
+	if _, ok := catalogOrders[c.Sort]; !ok {
+		c.Sort = "bump"
+	}
+	if !inputCatalog(db, &c.fullBoardInfo, board, c.Sort) {
+		http.NotFound(w, r)
+		return
+	}
+	c.setBoardView(true)
+	for i := range c.Threads {
+		processPostFile(&c.Threads[i].Op)
+	}
+
+	execTemplate(w, "catalog", &c)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// output laid out like 4chan read-only API, so that existing clients can use it
+
+const chanLastReplies = 5 // how many replies catalog.json includes for each thread
+
+type chanPost struct {
+	No          uint64     `json:"no"`
+	Resto       uint64     `json:"resto"`
+	Now         string     `json:"now"`
+	Time        int64      `json:"time"`
+	Name        string     `json:"name"`
+	Trip        string     `json:"trip,omitempty"`
+	Sub         string     `json:"sub,omitempty"`
+	Com         string     `json:"com,omitempty"`
+	Tim         int64      `json:"tim,omitempty"`
+	Ext         string     `json:"ext,omitempty"`
+	Filename    string     `json:"filename,omitempty"`
+	Replies     *int       `json:"replies,omitempty"` // OP only
+	Images      *int       `json:"images,omitempty"`  // OP only
+	Sticky      int        `json:"sticky,omitempty"`
+	Closed      int        `json:"closed,omitempty"`
+	LastReplies []chanPost `json:"last_replies,omitempty"`
+}
+
+type chanThreadPosts struct {
+	Posts []chanPost `json:"posts"`
+}
+
+type chanThreadsEntry struct {
+	No           uint64 `json:"no"`
+	LastModified int64  `json:"last_modified"`
+	Replies      int    `json:"replies"`
+}
+
+type chanThreadsPage struct {
+	Page    int                `json:"page"`
+	Threads []chanThreadsEntry `json:"threads"`
+}
+
+type chanCatalogPage struct {
+	Page    int        `json:"page"`
+	Threads []chanPost `json:"threads"`
+}
+
+func makeChanPost(p *fullPostInfo) (c chanPost) {
+	c = chanPost{
+		No:   p.Id,
+		Now:  time.Unix(p.Date, 0).UTC().Format("01/02/06(Mon)15:04:05"),
+		Time: p.Date,
+		Name: p.FName(),
+		Trip: p.Trip,
+		Com:  p.FMessage,
+	}
+	if p.HasSubject() {
+		c.Sub = p.FSubject()
+	}
+	if p.Banned {
+		c.Com += `<br><br><b style="color:red;">(USER WAS BANNED FOR THIS POST)</b>`
+	}
+	if !p.IsOp() {
+		c.Resto = p.Thread()
+	}
+	if p.HasFile() && p.File[0] != '/' {
+		ext := filepath.Ext(p.File)
+		tim, err := strconv.ParseInt(p.File[:len(p.File)-len(ext)], 10, 64)
+		if err == nil {
+			c.Tim = tim
+			c.Ext = ext
+			if p.HasOriginal() {
+				c.Filename = p.Original[:len(p.Original)-len(filepath.Ext(p.Original))]
+			} else {
+				c.Filename = p.File[:len(p.File)-len(ext)]
+			}
+		}
+	}
+	return
+}
+
+// OP with reply and image counters
+func makeChanOp(t *fullThreadInfo) (c chanPost) {
+	c = makeChanPost(&t.Op)
+	replies, images := t.NumReplies, t.NumImages
+	c.Replies, c.Images = &replies, &images
+	if t.Sticky {
+		c.Sticky = 1
+	}
+	if t.Locked {
+		c.Closed = 1
+	}
+	return
+}
+
+// threads split to pages like in board index
+func chanPageOf(i int) int {
+	if cfg.Limits.ThreadsPerPage <= 0 {
+		return 1
+	}
+	return i/cfg.Limits.ThreadsPerPage + 1
+}
+
+func render4chanThreads(w http.ResponseWriter, r *http.Request, board string) {
+	db := sqlPool()
+
+	var b fullBoardInfo
+	if !inputThreads(db, &b, board, 0, 0, 0) {
+		http.NotFound(w, r)
+		return
+	}
+
+	pages := []chanThreadsPage{}
+	for i := range b.Threads {
+		if pn := chanPageOf(i); len(pages) < pn {
+			pages = append(pages, chanThreadsPage{Page: pn, Threads: []chanThreadsEntry{}})
+		}
+		page := &pages[len(pages)-1]
+		page.Threads = append(page.Threads, chanThreadsEntry{
+			No:           b.Threads[i].Id,
+			LastModified: b.Threads[i].Bump,
+			Replies:      b.Threads[i].NumReplies,
+		})
+	}
+
+	execJSON(w, pages)
+}
+
+func render4chanCatalog(w http.ResponseWriter, r *http.Request, board string) {
+	db := sqlPool()
+
+	var b fullBoardInfo
+	if !inputThreads(db, &b, board, 0, 0, chanLastReplies) {
+		http.NotFound(w, r)
+		return
+	}
+	b.setBoardView(true)
+
+	processThreads(b.Threads, db)
+
+	pages := []chanCatalogPage{}
+	for i := range b.Threads {
+		if pn := chanPageOf(i); len(pages) < pn {
+			pages = append(pages, chanCatalogPage{Page: pn, Threads: []chanPost{}})
+		}
+		page := &pages[len(pages)-1]
+		t := &b.Threads[i]
+		c := makeChanOp(t)
+		for j := range t.Replies {
+			c.LastReplies = append(c.LastReplies, makeChanPost(&t.Replies[j]))
+		}
+		page.Threads = append(page.Threads, c)
+	}
+
+	execJSON(w, pages)
+}
+
+func render4chanThread(w http.ResponseWriter, r *http.Request, board string, thread uint64) {
+	db := sqlPool()
+
+	var t fullThreadInfo
+	t.postMap = make(map[uint64]int)
+	if !inputPosts(db, &t, board, thread) {
+		if !redirectMoved(w, r, board, thread, "res", ".json") {
+			http.NotFound(w, r)
+		}
+		return
+	}
+	t.setBoardView(false)
+	processThread(&t, db)
+
+	c := chanThreadPosts{Posts: make([]chanPost, 0, len(t.Replies)+1)}
+	c.Posts = append(c.Posts, makeChanOp(&t))
+	for i := range t.Replies {
+		c.Posts = append(c.Posts, makeChanPost(&t.Replies[i]))
+	}
+
+	execJSON(w, &c)
+}
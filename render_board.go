@@ -1,10 +1,13 @@
 package main
 
+import "strconv"
+
 // basic info about board
 type boardInfo struct {
 	Name      string
 	Desc      string
 	Info      string
+	Captcha   string // token of challenge for posting form, if board requires one
 	modView   bool
 	boardView bool
 }
@@ -27,5 +30,34 @@ func (b *boardInfo) IsBoardView() bool {
 
 type fullBoardInfo struct {
 	boardInfo
-	Threads []fullThreadInfo
+	Threads  []fullThreadInfo
+	Page     int
+	NumPages int
+}
+
+// list of page numbers, for navigation
+func (b *fullBoardInfo) Pages() []int {
+	pages := make([]int, b.NumPages)
+	for i := range pages {
+		pages[i] = i + 1
+	}
+	return pages
+}
+
+func (b *fullBoardInfo) PageUrl(page int) string {
+	if b.IsMod() {
+		if page <= 1 {
+			return "/" + b.Name + "/mod/"
+		}
+		return "/" + b.Name + "/mod/?page=" + strconv.Itoa(page)
+	}
+	if page <= 1 {
+		return "/" + b.Name + "/"
+	}
+	return "/" + b.Name + "/" + strconv.Itoa(page)
+}
+
+type fullCatalogInfo struct {
+	fullBoardInfo
+	Sort string
 }
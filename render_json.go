@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// machine-readable representations of front, board and thread data
+
+type jsonFile struct {
+	Name     string `json:"name"`
+	Original string `json:"original"`
+	Url      string `json:"url"`
+	Thumb    string `json:"thumb,omitempty"` // thumb url, if thumb can be displayed
+}
+
+type jsonPost struct {
+	Id        uint64     `json:"id"`
+	Thread    uint64     `json:"thread"`
+	Name      string     `json:"name"`
+	Trip      string     `json:"trip,omitempty"`
+	Subject   string     `json:"subject,omitempty"`
+	Email     string     `json:"email,omitempty"`
+	Date      int64      `json:"date"`
+	Message   string     `json:"message"`
+	FMessage  string     `json:"html"` // formatted message, same as in HTML view
+	File      *jsonFile  `json:"file,omitempty"`
+	Extra     []jsonFile `json:"extra_files,omitempty"` // attachments after first one
+	Backlinks []uint64   `json:"backlinks,omitempty"`   // posts referring to this post
+	Banned    bool       `json:"banned,omitempty"`      // user was publicly banned for this post
+	Deleted   bool       `json:"file_deleted,omitempty"`
+	Sage      bool       `json:"sage,omitempty"`
+}
+
+type jsonThread struct {
+	Id             uint64     `json:"id"`
+	Board          string     `json:"board"`
+	Op             jsonPost   `json:"op"`
+	Replies        []jsonPost `json:"replies"`
+	NumReplies     int        `json:"num_replies"`
+	NumImages      int        `json:"num_images"`
+	OmittedReplies int        `json:"omitted_replies,omitempty"` // in board index
+	OmittedImages  int        `json:"omitted_images,omitempty"`
+	Sticky         bool       `json:"sticky,omitempty"`
+	Locked         bool       `json:"locked,omitempty"`
+	Cyclical       bool       `json:"cyclical,omitempty"`
+}
+
+type jsonBoard struct {
+	Name     string       `json:"name"`
+	Desc     string       `json:"description"`
+	Info     string       `json:"info"`
+	Page     int          `json:"page,omitempty"`
+	NumPages int          `json:"num_pages,omitempty"`
+	Threads  []jsonThread `json:"threads,omitempty"`
+}
+
+type jsonFront struct {
+	Boards []jsonBoard `json:"boards"`
+}
+
+func makeJSONPost(p *fullPostInfo) (j jsonPost) {
+	j = jsonPost{
+		Id:       p.Id,
+		Thread:   p.Thread(),
+		Name:     p.Name,
+		Trip:     p.Trip,
+		Subject:  p.Subject,
+		Email:    p.Email,
+		Sage:     p.IsSage(),
+		Date:     p.Date,
+		Message:  p.Message,
+		FMessage: p.FMessage,
+		Banned:   p.Banned,
+		Deleted:  p.FileDeleted(),
+	}
+	if p.HasFile() {
+		j.File = &jsonFile{Name: p.File, Original: p.Original, Url: p.FullFile()}
+		if p.CanThumb() {
+			j.File.Thumb = p.FullThumb()
+		}
+	}
+	for i := range p.ExtraFiles {
+		f := &p.ExtraFiles[i]
+		jf := jsonFile{Name: f.File, Original: f.Original, Url: f.FullFile()}
+		if f.CanThumb() {
+			jf.Thumb = f.FullThumb()
+		}
+		j.Extra = append(j.Extra, jf)
+	}
+	for i := range p.References {
+		j.Backlinks = append(j.Backlinks, p.References[i].Id)
+	}
+	return
+}
+
+func makeJSONThread(t *fullThreadInfo) (j jsonThread) {
+	j = jsonThread{
+		Id:             t.Id,
+		Board:          t.Board(),
+		Op:             makeJSONPost(&t.Op),
+		NumReplies:     t.NumReplies,
+		NumImages:      t.NumImages,
+		OmittedReplies: t.OmittedReplies(),
+		OmittedImages:  t.OmittedImages(),
+		Sticky:         t.Sticky,
+		Locked:         t.Locked,
+		Cyclical:       t.Cyclical,
+	}
+	j.Replies = make([]jsonPost, 0, len(t.Replies))
+	for i := range t.Replies {
+		j.Replies = append(j.Replies, makeJSONPost(&t.Replies[i]))
+	}
+	return
+}
+
+func makeJSONBoard(b *fullBoardInfo) (j jsonBoard) {
+	j = jsonBoard{Name: b.Name, Desc: b.Desc, Info: b.Info, Page: b.Page, NumPages: b.NumPages}
+	for i := range b.Threads {
+		j.Threads = append(j.Threads, makeJSONThread(&b.Threads[i]))
+	}
+	return
+}
+
+func execJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		panic(err)
+	}
+}
+
+func renderFrontJSON(w http.ResponseWriter, r *http.Request) {
+	db := sqlPool()
+
+	var f fullFrontData
+	inputBoards(db, &f)
+
+	j := jsonFront{Boards: make([]jsonBoard, 0, len(f.Boards))}
+	for i := range f.Boards {
+		j.Boards = append(j.Boards, jsonBoard{Name: f.Boards[i].Name, Desc: f.Boards[i].Desc, Info: f.Boards[i].Info})
+	}
+
+	execJSON(w, &j)
+}
+
+func renderBoardJSON(w http.ResponseWriter, r *http.Request, board string, page int) {
+	db := sqlPool()
+
+	var b fullBoardInfo
+	if !inputThreads(db, &b, board, page, cfg.Limits.ThreadsPerPage, cfg.Limits.PreviewReplies) {
+		http.NotFound(w, r)
+		return
+	}
+	b.setBoardView(true)
+	processThreads(b.Threads, db)
+
+	j := makeJSONBoard(&b)
+	execJSON(w, &j)
+}
+
+func renderThreadJSON(w http.ResponseWriter, r *http.Request, board string, thread uint64) {
+	db := sqlPool()
+
+	var t fullThreadInfo
+	t.postMap = make(map[uint64]int)
+	if !inputPosts(db, &t, board, thread) {
+		if !redirectMoved(w, r, board, thread, "thread", ".json") {
+			http.NotFound(w, r)
+		}
+		return
+	}
+	t.setBoardView(false)
+	processThread(&t, db)
+
+	j := makeJSONThread(&t)
+	execJSON(w, &j)
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"fmt"
 	"net/url"
+	"strings"
 	"text/template"
 	"time"
+	"unicode/utf8"
 )
 
 // single post info
@@ -20,6 +22,7 @@ type postInfo struct {
 	File     string
 	Original string
 	Thumb    string
+	Banned   bool // user was publicly banned for this post
 }
 
 func (p *postInfo) Board() string {
@@ -34,15 +37,37 @@ func (p *postInfo) IsOp() bool {
 	return p.parent.Id == p.Id
 }
 
+func (p *postInfo) IsSticky() bool {
+	return p.parent.Sticky
+}
+
+func (p *postInfo) IsLocked() bool {
+	return p.parent.Locked
+}
+
+func (p *postInfo) IsCyclical() bool {
+	return p.parent.Cyclical
+}
+
+// file and thumb name of posts whose file was deleted
+const deletedFile = "/deleted"
+
 func (p *postInfo) HasFile() bool {
-	return p.File != ""
+	return p.File != "" && p.File != deletedFile
+}
+
+// whether post had file which was deleted
+func (p *postInfo) FileDeleted() bool {
+	return p.File == deletedFile
+}
+
+// first attachment, which is stored along with post
+func (p *postInfo) firstFile() *fileInfo {
+	return &fileInfo{parent: p, File: p.File, Original: p.Original, Thumb: p.Thumb}
 }
 
 func (p *postInfo) FullFile() string {
-	if p.HasFile() {
-		return "/" + p.Board() + "/src/" + p.File
-	}
-	return ""
+	return p.firstFile().FullFile()
 }
 
 func (p *postInfo) HasOriginal() bool {
@@ -50,18 +75,11 @@ func (p *postInfo) HasOriginal() bool {
 }
 
 func (p *postInfo) StrOriginal() string {
-	if p.HasOriginal() {
-		return template.HTMLEscapeString(p.Original)
-	}
-	return template.HTMLEscapeString(p.File)
+	return p.firstFile().StrOriginal()
 }
 
 func (p *postInfo) FullOriginal() string {
-	if p.HasOriginal() {
-		var u = url.URL{Path: p.FullFile() + "/" + p.Original}
-		return u.EscapedPath()
-	}
-	return p.FullFile()
+	return p.firstFile().FullOriginal()
 }
 
 // whether thumb can be displayed for this file
@@ -75,10 +93,48 @@ func (p *postInfo) HasThumb() bool {
 }
 
 func (p *postInfo) FullThumb() string {
-	if p.HasThumb() {
-		return urlThumb(p.Board(), p.Thumb)
+	return p.firstFile().FullThumb()
+}
+
+// attachment of post. first one is also in postInfo fields, see postFile
+type fileInfo struct {
+	parent   *postInfo
+	File     string
+	Original string
+	Thumb    string
+}
+
+func (f *fileInfo) FullFile() string {
+	if f.File != "" && f.File != deletedFile {
+		return "/" + f.parent.Board() + "/src/" + f.File
+	}
+	return ""
+}
+
+func (f *fileInfo) StrOriginal() string {
+	if f.Original != "" {
+		return template.HTMLEscapeString(f.Original)
+	}
+	return template.HTMLEscapeString(f.File)
+}
+
+func (f *fileInfo) FullOriginal() string {
+	if f.Original != "" {
+		var u = url.URL{Path: f.FullFile() + "/" + f.Original}
+		return u.EscapedPath()
+	}
+	return f.FullFile()
+}
+
+func (f *fileInfo) CanThumb() bool {
+	return f.Thumb != ""
+}
+
+func (f *fileInfo) FullThumb() string {
+	if len(f.Thumb) > 0 && f.Thumb[0] != '/' {
+		return urlThumb(f.parent.Board(), f.Thumb)
 	} else {
-		return urlStaticThumb(p.Board(), p.Thumb)
+		return urlStaticThumb(f.parent.Board(), f.Thumb)
 	}
 }
 
@@ -116,6 +172,15 @@ func (p *postInfo) FEmail() string {
 	return url.QueryEscape(p.Email)
 }
 
+// reply which didn't bump thread. sage of OP means nothing
+func (p *postInfo) IsSage() bool {
+	if p.IsOp() {
+		return false
+	}
+	_, sage, _, _ := emailOptions(p.Email)
+	return sage
+}
+
 func (p *postInfo) setMod(mod bool) {
 	p.parent.setMod(mod)
 }
@@ -148,9 +213,36 @@ func (p *postInfo) HasMessage() bool {
 	return p.Message != ""
 }
 
+const excerptLen = 160 // in characters
+
+// short unformatted beginning of message, for catalog
+func (p *postInfo) Excerpt() string {
+	m := strings.Join(strings.Fields(p.Message), " ")
+	if utf8.RuneCountInString(m) > excerptLen {
+		n, i := 0, 0
+		for i = range m {
+			if n == excerptLen {
+				break
+			}
+			n++
+		}
+		m = m[:i] + "..."
+	}
+	return template.HTMLEscapeString(m)
+}
+
 type fullPostInfo struct {
 	postInfo
 	FMessage   string
 	fparent    *fullThreadInfo
 	References []postReference
+	ExtraFiles []fileInfo // attachments after first one
+}
+
+// attachments of post, including extra ones
+func (p *fullPostInfo) NumFiles() int {
+	if !p.HasFile() {
+		return 0
+	}
+	return 1 + len(p.ExtraFiles)
 }
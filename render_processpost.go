@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"database/sql"
 	"fmt"
+	"github.com/lib/pq"
 	"mime"
 	"path/filepath"
 	"strconv"
@@ -252,30 +253,96 @@ func processPostMessage(p *fullPostInfo, db *sql.DB) {
 	p.FMessage = w.String()
 }
 
-func processPostThumb(p *fullPostInfo) {
-	ext := filepath.Ext(p.File)
+// static thumb for file which has no thumb of its own
+func staticThumbFor(fname string) string {
+	ext := filepath.Ext(fname)
 	mt := mime.TypeByExtension(ext)
 	if mt != "" {
 		mt, _, _ = mime.ParseMediaType(mt)
 	}
 	t := findStaticThumb(ext, mt)
 	if t != "" {
-		p.Thumb = "/" + t
+		return "/" + t
 	}
+	return ""
 }
 
-func processPost(p *fullPostInfo, db *sql.DB) {
-	processPostMessage(p, db)
+func processPostFile(p *fullPostInfo) {
 	if p.File != "" && p.File[0] != '/' && p.Thumb == "" {
-		processPostThumb(p)
+		p.Thumb = staticThumbFor(p.File)
 	}
 }
 
-func processThread(t *fullThreadInfo, db *sql.DB) {
-	processPost(&t.Op, db)
+// loads attachments beyond first one for all given posts of board in single query.
+// posts without first file can't have them
+func loadExtraFiles(db *sql.DB, board string, posts []*fullPostInfo) {
+	byId := make(map[uint64]*fullPostInfo)
+	var ids []int64
+	for _, p := range posts {
+		if p.HasFile() {
+			byId[p.Id] = p
+			ids = append(ids, int64(p.Id))
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	q := "SELECT post, file, original, thumb FROM %s.post_files WHERE post = ANY($1) ORDER BY post ASC, idx ASC"
+	rows, err := boardStmt(db, board, q).Query(pq.Array(ids))
+	panicErr(err)
+	defer rows.Close()
+	for rows.Next() {
+		var id uint64
+		var f fileInfo
+		err = rows.Scan(&id, &f.File, &f.Original, &f.Thumb)
+		panicErr(err)
+		p := byId[id]
+		f.parent = &p.postInfo
+		if f.File != "" && f.File[0] != '/' && f.Thumb == "" {
+			f.Thumb = staticThumbFor(f.File)
+		}
+		p.ExtraFiles = append(p.ExtraFiles, f)
+	}
+}
+
+// posts of thread, OP first
+func threadPosts(t *fullThreadInfo) []*fullPostInfo {
+	posts := make([]*fullPostInfo, 0, len(t.Replies)+1)
+	posts = append(posts, &t.Op)
 	for i := range t.Replies {
-		processPost(&t.Replies[i], db)
+		posts = append(posts, &t.Replies[i])
+	}
+	return posts
+}
+
+// doesn't load extra files, which are loaded for many posts at once
+func processPost(p *fullPostInfo, db *sql.DB) {
+	processPostMessage(p, db)
+	processPostFile(p)
+}
+
+func processThread(t *fullThreadInfo, db *sql.DB) {
+	posts := threadPosts(t)
+	for _, p := range posts {
+		processPost(p, db)
 	}
+	loadExtraFiles(db, t.Board(), posts)
+}
+
+// same as processThread for every thread, with extra files of all of them loaded at once
+func processThreads(ts []fullThreadInfo, db *sql.DB) {
+	if len(ts) == 0 {
+		return
+	}
+	var posts []*fullPostInfo
+	for i := range ts {
+		tp := threadPosts(&ts[i])
+		for _, p := range tp {
+			processPost(p, db)
+		}
+		posts = append(posts, tp...)
+	}
+	loadExtraFiles(db, ts[0].Board(), posts)
 }
 
 // also sets up backlinks
@@ -296,8 +363,11 @@ func localValidatePost(p *fullPostInfo, post uint64, thread *uint64) {
 }
 
 func sqlValidateBoard(db *sql.DB, board string) bool {
+	if !validBoardName(board) {
+		return false
+	}
 	var bname string
-	err := db.QueryRow("SELECT name FROM boards WHERE name=$1", board).Scan(&bname)
+	err := sqlStmt(db, "SELECT name FROM boards WHERE name=$1").QueryRow(board).Scan(&bname)
 	if err == sql.ErrNoRows {
 		return false
 	}
@@ -307,7 +377,7 @@ func sqlValidateBoard(db *sql.DB, board string) bool {
 
 func sqlValidatePost(db *sql.DB, board string, post uint64, thread *uint64) bool {
 	var tid sql.NullInt64
-	err := db.QueryRow(fmt.Sprintf("SELECT thread FROM %s.posts WHERE id=$1", board), post).Scan(&tid)
+	err := boardStmt(db, board, "SELECT thread FROM %s.posts WHERE id=$1").QueryRow(post).Scan(&tid)
 	if err == sql.ErrNoRows {
 		return false
 	}
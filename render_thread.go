@@ -1,8 +1,12 @@
 package main
 
 type threadInfo struct {
-	parent *boardInfo
-	Id     uint64
+	parent   *boardInfo
+	Id       uint64
+	Bump     int64
+	Sticky   bool // stays on top of board index, not pruned
+	Locked   bool // no more replies allowed
+	Cyclical bool // oldest replies are dropped after bump limit
 }
 
 func (t *threadInfo) Board() string {
@@ -17,6 +21,14 @@ func (t *threadInfo) IsMod() bool {
 	return t.parent.IsMod()
 }
 
+func (t *threadInfo) setCaptcha(token string) {
+	t.parent.Captcha = token
+}
+
+func (t *threadInfo) Captcha() string {
+	return t.parent.Captcha
+}
+
 func (t *threadInfo) setBoardView(bw bool) {
 	t.parent.setBoardView(bw)
 }
@@ -27,7 +39,22 @@ func (t *threadInfo) IsBoardView() bool {
 
 type fullThreadInfo struct {
 	threadInfo
-	Op      fullPostInfo
-	Replies []fullPostInfo
-	postMap map[uint64]int
+	Op         fullPostInfo
+	Replies    []fullPostInfo
+	NumReplies int // total, not only loaded ones
+	NumImages  int
+	postMap    map[uint64]int
+}
+
+// replies not shown in board view
+func (t *fullThreadInfo) OmittedReplies() int {
+	return t.NumReplies - len(t.Replies)
+}
+
+func (t *fullThreadInfo) OmittedImages() int {
+	n := t.NumImages
+	for i := range t.Replies {
+		n -= t.Replies[i].NumFiles()
+	}
+	return n
 }
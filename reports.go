@@ -0,0 +1,227 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// categories posts can be reported for, same as in post.tmpl
+var reportCategories = []string{"rules", "spam", "illegal"}
+
+func validReportCategory(c string) bool {
+	for _, rc := range reportCategories {
+		if rc == c {
+			return true
+		}
+	}
+	return false
+}
+
+const maxReportComment = 500
+
+// reports made per address. counted apart from reports table, as that is
+// emptied when moderators handle reports
+var reportCounts = newHourlyCounter()
+
+// report of post by user
+func postReport(w http.ResponseWriter, r *http.Request, board string) {
+	r.ParseForm()
+	spost, ok := r.PostForm["id"]
+	if !ok {
+		reportError(w, r, newReqError(400, errMissingField, "no post id specified"))
+		return
+	}
+	post, err := strconv.ParseUint(spost[0], 10, 64)
+	if err != nil {
+		reportError(w, r, newReqError(400, errBadPostId, "bad post id"))
+		return
+	}
+	category := r.PostFormValue("category")
+	if !validReportCategory(category) {
+		reportError(w, r, newReqError(400, errBadRequest, "bad report category"))
+		return
+	}
+	comment := strings.TrimSpace(r.PostFormValue("comment"))
+	if len([]rune(comment)) > maxReportComment {
+		reportError(w, r, newReqError(400, errBadRequest, "comment too long"))
+		return
+	}
+
+	db := sqlPool()
+
+	if !sqlValidateBoard(db, board) {
+		reportError(w, r, newReqError(404, errBoardNotFound, "board not found"))
+		return
+	}
+
+	if !checkBan(w, r, db) {
+		return
+	}
+
+	var thread sql.NullInt64
+	err = boardStmt(db, board, "SELECT thread FROM %s.posts WHERE id=$1").QueryRow(post).Scan(&thread)
+	if err == sql.ErrNoRows {
+		reportError(w, r, newReqError(404, errPostNotFound, "post not found"))
+		return
+	}
+	panicErr(err)
+
+	var rr postResult
+	rr.Board, rr.Post = board, post
+	if thread.Valid && thread.Int64 != 0 {
+		rr.Thread = uint64(thread.Int64)
+	} else {
+		rr.Thread = post
+	}
+
+	ip := clientIP(r)
+	if reportCounts.wait(ip, cfg.Limits.ReportsPerHour) > 0 {
+		reportError(w, r, newReqError(429, errRateLimited, "too many reports, try again later"))
+		return
+	}
+	reportCounts.add(ip)
+
+	// repeated report of same post from same address is ignored
+	q := `INSERT INTO reports (board, post, category, comment, ip_addr, date) VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT DO NOTHING`
+	_, err = sqlStmt(db, q).Exec(board, post, category, comment, sqlIP(ip), utcUnixTime())
+	panicErr(err)
+
+	reportResult(w, r, "reported", &rr)
+}
+
+// drops reports of post. done when post is handled by moderator
+func clearReports(db *sql.DB, board string, post uint64) {
+	_, err := sqlStmt(db, "DELETE FROM reports WHERE board=$1 AND post=$2").Exec(board, post)
+	panicErr(err)
+}
+
+// drops reports of posts of board which no longer exist, such as replies of deleted thread
+func pruneReports(db *sql.DB, board string) {
+	q := "DELETE FROM reports AS r WHERE r.board=$1 AND NOT EXISTS (SELECT 1 FROM %s.posts AS p WHERE p.id = r.post)"
+	_, err := boardStmt(db, board, q).Exec(board)
+	panicErr(err)
+}
+
+type reportEntry struct {
+	Category string `json:"category"`
+	Comment  string `json:"comment"`
+	Date     int64  `json:"date"`
+}
+
+// reports of single post
+type reportedPost struct {
+	Board   string        `json:"board"`
+	Thread  uint64        `json:"thread"`
+	Id      uint64        `json:"post"`
+	Reports []reportEntry `json:"reports"`
+	Post    *fullPostInfo `json:"-"`
+}
+
+type reportsInfo struct {
+	Posts []*reportedPost
+}
+
+// loads reported post for display in queue. returns nil if it's gone
+func inputReportedPost(db *sql.DB, board string, post uint64) *fullPostInfo {
+	if !validBoardName(board) {
+		return nil
+	}
+	var thread sql.NullInt64
+	err := boardStmt(db, board, "SELECT thread FROM %s.posts WHERE id=$1").QueryRow(post).Scan(&thread)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	panicErr(err)
+
+	t := &fullThreadInfo{postMap: make(map[uint64]int)}
+	t.parent = &boardInfo{Name: board}
+	t.Id = post
+	if thread.Valid && thread.Int64 != 0 {
+		t.Id = uint64(thread.Int64)
+	}
+
+	p := &t.Op
+	p.parent = &t.threadInfo
+	p.fparent = t
+	row := boardStmt(db, board, "SELECT "+postColumns+" FROM %s.posts WHERE id=$1").QueryRow(post)
+	err = scanPost(row, p)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	panicErr(err)
+	t.postMap[p.Id] = 0
+	return p
+}
+
+// moderation queue. moderators only see reports of their boards
+func renderReports(w http.ResponseWriter, r *http.Request, a *adminAccount) {
+	db := sqlPool()
+
+	q := `SELECT board, post, category, comment, date FROM reports
+	WHERE $1 OR board IN (SELECT board FROM admin_boards WHERE username=$2)
+	ORDER BY board, post, date`
+	rows, err := sqlStmt(db, q).Query(a.IsAdmin(), a.Name)
+	panicErr(err)
+	defer rows.Close()
+
+	var ri reportsInfo
+	var cur *reportedPost
+	for rows.Next() {
+		var board string
+		var post uint64
+		var e reportEntry
+		err = rows.Scan(&board, &post, &e.Category, &e.Comment, &e.Date)
+		panicErr(err)
+		if cur == nil || cur.Board != board || cur.Id != post {
+			cur = &reportedPost{Board: board, Id: post}
+			ri.Posts = append(ri.Posts, cur)
+		}
+		cur.Reports = append(cur.Reports, e)
+	}
+
+	// attach posts, skipping ones which are already gone
+	posts := ri.Posts[:0]
+	for _, rp := range ri.Posts {
+		rp.Post = inputReportedPost(db, rp.Board, rp.Id)
+		if rp.Post == nil {
+			continue
+		}
+		rp.Thread = rp.Post.Thread()
+		rp.Post.setMod(true)
+		processPost(rp.Post, db)
+		loadExtraFiles(db, rp.Board, []*fullPostInfo{rp.Post})
+		posts = append(posts, rp)
+	}
+	ri.Posts = posts
+
+	if wantJSON(r) {
+		execJSON(w, ri.Posts)
+	} else {
+		execTemplate(w, "reports", &ri)
+	}
+}
+
+// dismisses reports of post without doing anything to it
+func postReportsDismiss(w http.ResponseWriter, r *http.Request, a *adminAccount) {
+	r.ParseForm()
+	board := r.PostFormValue("board")
+	post, err := strconv.ParseUint(r.PostFormValue("id"), 10, 64)
+	if err != nil {
+		reportError(w, r, newReqError(400, errBadPostId, "bad post id"))
+		return
+	}
+
+	db := sqlPool()
+
+	if !a.mayModerate(db, board) {
+		reportError(w, r, newReqError(403, errForbidden, "not allowed to moderate this board"))
+		return
+	}
+
+	clearReports(db, board, post)
+
+	reportDone(w, r, "/mod/reports")
+}
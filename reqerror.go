@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// stable error codes reported to JSON clients
+const (
+	errBadRequest     = "bad_request"
+	errMissingField   = "missing_field"
+	errBadPostId      = "bad_post_id"
+	errBoardNotFound  = "board_not_found"
+	errBoardExists    = "board_exists"
+	errThreadNotFound = "thread_not_found"
+	errPostNotFound   = "post_not_found"
+	errFileNotAllowed = "file_type_not_allowed"
+	errFileTooBig     = "file_too_big"
+	errInternal       = "internal_error"
+	errNotLoggedIn    = "not_logged_in"
+	errForbidden      = "forbidden"
+	errBanned         = "banned"
+	errNoAddress      = "no_address"
+	errBanNotFound    = "ban_not_found"
+	errRateLimited    = "rate_limited"
+	errDuplicate      = "duplicate"
+	errThreadLocked   = "thread_locked"
+	errWrongPassword  = "wrong_password"
+	errBadCaptcha     = "bad_captcha"
+	errTooManyFiles   = "too_many_files"
+)
+
+// error which should be reported to client
+type reqError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"error"`
+	Message string `json:"message"`
+}
+
+func (e *reqError) Error() string {
+	return e.Message
+}
+
+func newReqError(status int, code, msg string) *reqError {
+	return &reqError{Status: status, Code: code, Message: msg}
+}
+
+// whether client wants structured response instead of HTML.
+// picked by .json suffix in path or by Accept header
+func wantJSON(r *http.Request) bool {
+	if strings.HasSuffix(r.URL.Path, ".json") {
+		return true
+	}
+	for _, a := range r.Header["Accept"] {
+		for _, t := range strings.Split(a, ",") {
+			if i := strings.IndexByte(t, ';'); i != -1 {
+				t = t[:i]
+			}
+			if strings.TrimSpace(t) == "application/json" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func reportError(w http.ResponseWriter, r *http.Request, e *reqError) {
+	if wantJSON(r) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(e.Status)
+		if err := json.NewEncoder(w).Encode(e); err != nil {
+			panic(err)
+		}
+	} else {
+		http.Error(w, fmt.Sprintf("%d %s: %s", e.Status, strings.ToLower(http.StatusText(e.Status)), e.Message), e.Status)
+	}
+}
+
+// after successful action, sends browser to url, or reports success to JSON client
+func reportDone(w http.ResponseWriter, r *http.Request, url string) {
+	if wantJSON(r) {
+		execJSON(w, map[string]bool{"ok": true})
+	} else {
+		http.Redirect(w, r, url, http.StatusSeeOther)
+	}
+}
+
+// reports successful result either as JSON or as named template
+func reportResult(w http.ResponseWriter, r *http.Request, tmpl string, data interface{}) {
+	if wantJSON(r) {
+		execJSON(w, data)
+	} else {
+		execTemplate(w, tmpl, data)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// editable settings of board
+type boardSettings struct {
+	Name       string `json:"name"`
+	Desc       string `json:"description"`
+	Info       string `json:"info"`
+	MaxThreads int    `json:"maxthreads"` // 0 means unlimited
+	BumpLimit  int    `json:"bumplimit"`  // 0 means unlimited
+	PublicLog  bool   `json:"publiclog"`
+	// posting limits in seconds, nil means server default and 0 disables limit
+	ReplyCooldown  *int `json:"replycooldown"`
+	ThreadCooldown *int `json:"threadcooldown"`
+	DupWindow      *int `json:"dupwindow"`
+	Captcha        bool `json:"captcha"`  // whether posting requires solving captcha
+	MaxFiles       *int `json:"maxfiles"` // attachments per post, nil means server default
+}
+
+func inputBoardSettings(db *sql.DB, board string, s *boardSettings) bool {
+	var maxthreads, bumplimit, reply, thread, dup, maxfiles sql.NullInt64
+	q := "SELECT name, description, info, maxthreads, bumplimit, publiclog, replycooldown, threadcooldown, dupwindow, captcha, maxfiles FROM boards WHERE name=$1"
+	err := sqlStmt(db, q).QueryRow(board).Scan(&s.Name, &s.Desc, &s.Info, &maxthreads, &bumplimit, &s.PublicLog, &reply, &thread, &dup, &s.Captcha, &maxfiles)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	panicErr(err)
+	s.MaxThreads, s.BumpLimit = int(maxthreads.Int64), int(bumplimit.Int64)
+	s.ReplyCooldown, s.ThreadCooldown, s.DupWindow = optLimit(reply), optLimit(thread), optLimit(dup)
+	s.MaxFiles = optLimit(maxfiles)
+	return true
+}
+
+func nullLimit(n int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(n), Valid: n > 0}
+}
+
+// for settings where 0 is meaningful, nil is stored as NULL
+func nullOptLimit(n *int) sql.NullInt64 {
+	if n == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(*n), Valid: true}
+}
+
+func optLimit(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	v := int(n.Int64)
+	return &v
+}
+
+// optional setting as shown in forms, empty if server default is used
+func strOptLimit(n *int) string {
+	if n == nil {
+		return ""
+	}
+	return strconv.Itoa(*n)
+}
+
+func (s *boardSettings) StrReplyCooldown() string  { return strOptLimit(s.ReplyCooldown) }
+func (s *boardSettings) StrThreadCooldown() string { return strOptLimit(s.ThreadCooldown) }
+func (s *boardSettings) StrDupWindow() string      { return strOptLimit(s.DupWindow) }
+func (s *boardSettings) StrMaxFiles() string       { return strOptLimit(s.MaxFiles) }
+
+// stores settings and applies them right away
+func saveBoardSettings(db *sql.DB, s *boardSettings, actor string) {
+	q := `UPDATE boards SET description=$2, info=$3, maxthreads=$4, bumplimit=$5, publiclog=$6,
+		replycooldown=$7, threadcooldown=$8, dupwindow=$9, captcha=$10, maxfiles=$11
+	WHERE name=$1`
+	_, err := sqlStmt(db, q).Exec(s.Name, s.Desc, s.Info, nullLimit(s.MaxThreads), nullLimit(s.BumpLimit), s.PublicLog,
+		nullOptLimit(s.ReplyCooldown), nullOptLimit(s.ThreadCooldown), nullOptLimit(s.DupWindow), s.Captcha, nullOptLimit(s.MaxFiles))
+	panicErr(err)
+
+	logAction(db, &logEntry{Actor: actor, Action: logEditBoard, Board: s.Name,
+		Reason: fmt.Sprintf("maxthreads=%d bumplimit=%d publiclog=%t replycooldown=%s threadcooldown=%s dupwindow=%s captcha=%t maxfiles=%s",
+			s.MaxThreads, s.BumpLimit, s.PublicLog, s.StrReplyCooldown(), s.StrThreadCooldown(), s.StrDupWindow(), s.Captcha, s.StrMaxFiles())})
+
+	if s.MaxThreads > 0 {
+		pruneExcessThreads(db, s.Name, s.MaxThreads)
+	}
+}
+
+// sets setting from textual value. used by both web form and CLI
+func (s *boardSettings) set(key, value string) error {
+	var err error
+	switch key {
+	case "desc", "description":
+		s.Desc = value
+	case "info":
+		s.Info = value
+	case "maxthreads":
+		s.MaxThreads, err = parseLimit(value)
+	case "bumplimit":
+		s.BumpLimit, err = parseLimit(value)
+	case "publiclog":
+		s.PublicLog, err = strconv.ParseBool(value)
+	case "replycooldown":
+		s.ReplyCooldown, err = parseOptLimit(value)
+	case "threadcooldown":
+		s.ThreadCooldown, err = parseOptLimit(value)
+	case "dupwindow":
+		s.DupWindow, err = parseOptLimit(value)
+	case "captcha":
+		s.Captcha, err = strconv.ParseBool(value)
+	case "maxfiles":
+		s.MaxFiles, err = parseOptLimit(value)
+	default:
+		return fmt.Errorf("unknown setting %s", key)
+	}
+	if err != nil {
+		return fmt.Errorf("bad value of %s", key)
+	}
+	return nil
+}
+
+// like parseLimit, but empty means server default and 0 is kept
+func parseOptLimit(s string) (*int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 31)
+	if err != nil {
+		return nil, err
+	}
+	v := int(n)
+	return &v, nil
+}
+
+// limit from form value, empty means unlimited
+func parseLimit(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 31)
+	return int(n), err
+}
+
+func renderBoardSettings(w http.ResponseWriter, r *http.Request) {
+	var s boardSettings
+	board := r.FormValue("board")
+	if !validBoardName(board) || !inputBoardSettings(sqlPool(), board, &s) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if wantJSON(r) {
+		execJSON(w, &s)
+	} else {
+		execTemplate(w, "settings", &s)
+	}
+}
+
+func postBoardSettings(w http.ResponseWriter, r *http.Request, a *adminAccount) {
+	r.ParseForm()
+	board := r.PostFormValue("board")
+
+	db := sqlPool()
+
+	var s boardSettings
+	if !validBoardName(board) || !inputBoardSettings(db, board, &s) {
+		reportError(w, r, newReqError(404, errBoardNotFound, "board not found"))
+		return
+	}
+
+	// fields not present in form are left unchanged. checkbox is only sent when set,
+	// so missing ones are taken as unset if hidden field tells that form includes them
+	for _, key := range []string{"desc", "info", "maxthreads", "bumplimit", "replycooldown", "threadcooldown", "dupwindow", "maxfiles"} {
+		if v, ok := r.PostForm[key]; ok {
+			if err := s.set(key, strings.TrimSpace(v[0])); err != nil {
+				reportError(w, r, newReqError(400, errBadRequest, err.Error()))
+				return
+			}
+		}
+	}
+	for _, key := range []string{"publiclog", "captcha"} {
+		v, ok := r.PostForm[key]
+		if !ok {
+			if r.PostFormValue("hasbools") == "" {
+				continue
+			}
+			v = []string{"false"}
+		}
+		if err := s.set(key, v[0]); err != nil {
+			reportError(w, r, newReqError(400, errBadRequest, err.Error()))
+			return
+		}
+	}
+
+	saveBoardSettings(db, &s, a.Name)
+
+	reportDone(w, r, "/mod/settings?board="+board)
+}
+
+// edits board settings from command line: boardset <board> key=value...
+func boardSetCmd(board string, args []string) {
+	if board == "" {
+		fmt.Printf("usage: boardset <board> [desc=...] [info=...] [maxthreads=N] [bumplimit=N] [publiclog=true|false]\n")
+		fmt.Printf("                        [replycooldown=N] [threadcooldown=N] [dupwindow=N] [captcha=true|false] [maxfiles=N]\n")
+		return
+	}
+
+	db := sqlPool()
+
+	var s boardSettings
+	if !validBoardName(board) || !inputBoardSettings(db, board, &s) {
+		fmt.Printf("error: board does not exist\n")
+		return
+	}
+
+	for _, arg := range args {
+		i := strings.IndexByte(arg, '=')
+		if i == -1 {
+			fmt.Printf("error: expected key=value, got %s\n", arg)
+			return
+		}
+		if err := s.set(arg[:i], arg[i+1:]); err != nil {
+			fmt.Printf("error: %s\n", err)
+			return
+		}
+	}
+
+	if len(args) != 0 {
+		saveBoardSettings(db, &s, "")
+	}
+
+	fmt.Printf("name: %s\ndescription: %s\ninfo: %s\nmaxthreads: %d\nbumplimit: %d\npubliclog: %t\n",
+		s.Name, s.Desc, s.Info, s.MaxThreads, s.BumpLimit, s.PublicLog)
+	fmt.Printf("replycooldown: %s\nthreadcooldown: %s\ndupwindow: %s\ncaptcha: %t\nmaxfiles: %s\n",
+		s.StrReplyCooldown(), s.StrThreadCooldown(), s.StrDupWindow(), s.Captcha, s.StrMaxFiles())
+}
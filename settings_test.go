@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestParseOptLimit(t *testing.T) {
+	type limitset struct {
+		src string
+		str string // as shown in form, empty for server default
+		ok  bool
+	}
+	var tests = [...]limitset{
+		{src: "", str: "", ok: true},
+		{src: "0", str: "0", ok: true},
+		{src: "4", str: "4", ok: true},
+		{src: "-1", str: "", ok: false},
+		{src: "x", str: "", ok: false},
+		{src: "9999999999", str: "", ok: false},
+	}
+	for i := range tests {
+		n, err := parseOptLimit(tests[i].src)
+		if (err == nil) != tests[i].ok {
+			t.Errorf("parseOptLimit(%q): expected ok: %v; got error: %v\n", tests[i].src, tests[i].ok, err)
+			continue
+		}
+		if str := strOptLimit(n); str != tests[i].str {
+			t.Errorf("parseOptLimit(%q): expected: %q; got: %q\n", tests[i].src, tests[i].str, str)
+		}
+	}
+}
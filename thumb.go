@@ -11,16 +11,6 @@ import (
 	"time"
 )
 
-const (
-	thumbBgOp    = "red"
-	thumbBgReply = "#D6DAF0"
-)
-
-const (
-	thumbMaxW = 128
-	thumbMaxH = 128
-)
-
 const (
 	thumbIMagick = iota
 	thumbConvert
@@ -99,7 +89,7 @@ func runConvertCmd(gm bool, source, destdir, dest, destext, bgcolor string) erro
 		convsrc = source + "[0]"
 	}
 
-	args = append(args, convsrc, "-thumbnail", fmt.Sprintf("%dx%d", thumbMaxW, thumbMaxH))
+	args = append(args, convsrc, "-thumbnail", fmt.Sprintf("%dx%d", cfg.Thumbs.MaxWidth, cfg.Thumbs.MaxHeight))
 	if bgcolor != "" {
 		args = append(args, "-background", bgcolor, "-flatten")
 	}
@@ -140,9 +130,9 @@ func makeThumb(fullname, fname, board, ext, mimetype string, isop bool) (string,
 
 	var bgcolor string
 	if isop {
-		bgcolor = thumbBgOp
+		bgcolor = cfg.Thumbs.BgOp
 	} else {
-		bgcolor = thumbBgReply
+		bgcolor = cfg.Thumbs.BgReply
 	}
 
 	m, ok := thumbMethods[method]
@@ -161,11 +151,14 @@ func makeThumb(fullname, fname, board, ext, mimetype string, isop bool) (string,
 func makeThumbs(method, board, file string) {
 	var err error
 
-	db := openSQL()
-	defer db.Close()
+	db := sqlPool()
 
 	var bname string
-	err = db.QueryRow("SELECT name FROM boards WHERE name=$1", board).Scan(&bname)
+	if !validBoardName(board) {
+		fmt.Printf("error: invalid board name")
+		return
+	}
+	err = sqlStmt(db, "SELECT name FROM boards WHERE name=$1").QueryRow(board).Scan(&bname)
 	if err == sql.ErrNoRows {
 		fmt.Printf("error: board does not exist")
 		return
@@ -174,14 +167,16 @@ func makeThumbs(method, board, file string) {
 
 	var rows *sql.Rows
 	if file == "" {
-		rows, err = db.Query(fmt.Sprintf("SELECT id, thread, file, thumb FROM %s.posts", board))
+		rows, err = boardStmt(db, board, "SELECT id, thread, file, thumb FROM %s.posts").Query()
 	} else {
-		rows, err = db.Query(fmt.Sprintf("SELECT id, thread, file, thumb FROM %s.posts WHERE file=$1", board), file)
+		rows, err = boardStmt(db, board, "SELECT id, thread, file, thumb FROM %s.posts WHERE file=$1").Query(file)
 	}
 	panicErr(err)
+	defer rows.Close()
 
 	type tpost struct {
 		id, thread  uint64
+		idx         int // 0 for first file, which is in posts table
 		file, thumb string
 	}
 
@@ -203,6 +198,28 @@ func makeThumbs(method, board, file string) {
 		}
 	}
 
+	if file == "" {
+		rows, err = boardStmt(db, board, "SELECT p.id, p.thread, f.idx, f.file, f.thumb FROM %[1]s.post_files AS f JOIN %[1]s.posts AS p ON p.id = f.post").Query()
+	} else {
+		rows, err = boardStmt(db, board, "SELECT p.id, p.thread, f.idx, f.file, f.thumb FROM %[1]s.post_files AS f JOIN %[1]s.posts AS p ON p.id = f.post WHERE f.file=$1").Query(file)
+	}
+	panicErr(err)
+	defer rows.Close()
+	for rows.Next() {
+		var p tpost
+		var pthread sql.NullInt64
+		err = rows.Scan(&p.id, &pthread, &p.idx, &p.file, &p.thumb)
+		panicErr(err)
+		if p.file != "" && p.file[0] != '/' && (len(p.thumb) < 1 || p.thumb[0] != '/') {
+			if !pthread.Valid || pthread.Int64 == 0 || uint64(pthread.Int64) == p.id {
+				p.thread = p.id
+			} else {
+				p.thread = uint64(pthread.Int64)
+			}
+			modthumbs = append(modthumbs, p)
+		}
+	}
+
 	fmt.Printf("will regenerate %d thumbs\n", len(modthumbs))
 
 	var total_time uint64 = 0
@@ -228,9 +245,12 @@ func makeThumbs(method, board, file string) {
 		}
 		total_time += spent
 		if ntname != modthumbs[i].thumb {
-			stmt, err := db.Prepare(fmt.Sprintf("UPDATE %s.posts SET thumb = $1 WHERE id = $2", board))
-			panicErr(err)
-			_, err = stmt.Exec(ntname, modthumbs[i].id)
+			var err error
+			if modthumbs[i].idx == 0 {
+				_, err = boardStmt(db, board, "UPDATE %s.posts SET thumb = $1 WHERE id = $2").Exec(ntname, modthumbs[i].id)
+			} else {
+				_, err = boardStmt(db, board, "UPDATE %s.post_files SET thumb = $1 WHERE post = $2 AND idx = $3").Exec(ntname, modthumbs[i].id, modthumbs[i].idx)
+			}
 			panicErr(err)
 
 			if modthumbs[i].thumb != "" {
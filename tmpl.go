@@ -11,13 +11,25 @@ var loadedTemplates *template.Template
 var templateNames []struct{ n, f string } = []struct{ n, f string }{
 	{"front", "front.tmpl"},
 	{"board", "board.tmpl"},
+	{"catalog", "catalog.tmpl"},
 	{"thread", "thread.tmpl"},
 	{"post", "post.tmpl"},
 	{"posted", "posted.tmpl"},
 	{"threadcreated", "threadcreated.tmpl"},
+	{"posterror", "posterror.tmpl"},
 	{"deleted", "deleted.tmpl"},
+	{"postdeleted", "postdeleted.tmpl"},
 	{"boardcreated", "boardcreated.tmpl"},
 	{"boarddeleted", "boarddeleted.tmpl"},
+	{"login", "login.tmpl"},
+	{"banned", "banned.tmpl"},
+	{"postbanned", "postbanned.tmpl"},
+	{"bans", "bans.tmpl"},
+	{"log", "log.tmpl"},
+	{"reported", "reported.tmpl"},
+	{"reports", "reports.tmpl"},
+	{"settings", "settings.tmpl"},
+	{"moved", "moved.tmpl"},
 }
 
 func parseFromFile(t *template.Template, fname string) (*template.Template, error) {